@@ -7,6 +7,7 @@ import (
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 // This is a compile-time assertion to ensure that this generated file
@@ -20,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion7
 type CaptchaClient interface {
 	Get(ctx context.Context, in *User, opts ...grpc.CallOption) (*Challenge, error)
 	Validate(ctx context.Context, in *Solution, opts ...grpc.CallOption) (*Status, error)
+	GetBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (Captcha_GetBatchClient, error)
 }
 
 type captchaClient struct {
@@ -48,12 +50,45 @@ func (c *captchaClient) Validate(ctx context.Context, in *Solution, opts ...grpc
 	return out, nil
 }
 
+func (c *captchaClient) GetBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (Captcha_GetBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Captcha_ServiceDesc.Streams[0], "/api.Captcha/GetBatch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &captchaGetBatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Captcha_GetBatchClient interface {
+	Recv() (*Challenge, error)
+	grpc.ClientStream
+}
+
+type captchaGetBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *captchaGetBatchClient) Recv() (*Challenge, error) {
+	m := new(Challenge)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // CaptchaServer is the server API for Captcha service.
 // All implementations must embed UnimplementedCaptchaServer
 // for forward compatibility
 type CaptchaServer interface {
 	Get(context.Context, *User) (*Challenge, error)
 	Validate(context.Context, *Solution) (*Status, error)
+	GetBatch(*BatchRequest, Captcha_GetBatchServer) error
 	mustEmbedUnimplementedCaptchaServer()
 }
 
@@ -67,6 +102,9 @@ func (UnimplementedCaptchaServer) Get(context.Context, *User) (*Challenge, error
 func (UnimplementedCaptchaServer) Validate(context.Context, *Solution) (*Status, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
 }
+func (UnimplementedCaptchaServer) GetBatch(*BatchRequest, Captcha_GetBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBatch not implemented")
+}
 func (UnimplementedCaptchaServer) mustEmbedUnimplementedCaptchaServer() {}
 
 // UnsafeCaptchaServer may be embedded to opt out of forward compatibility for this service.
@@ -116,6 +154,27 @@ func _Captcha_Validate_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Captcha_GetBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CaptchaServer).GetBatch(m, &captchaGetBatchServer{stream})
+}
+
+type Captcha_GetBatchServer interface {
+	Send(*Challenge) error
+	grpc.ServerStream
+}
+
+type captchaGetBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *captchaGetBatchServer) Send(m *Challenge) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Captcha_ServiceDesc is the grpc.ServiceDesc for Captcha service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -132,6 +191,286 @@ var Captcha_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Captcha_Validate_Handler,
 		},
 	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetBatch",
+			Handler:       _Captcha_GetBatch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "captcha.proto3",
+}
+
+// CaptchaAdminClient is the client API for CaptchaAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CaptchaAdminClient interface {
+	Lookup(ctx context.Context, in *ChallengeRef, opts ...grpc.CallOption) (*ChallengeInfo, error)
+	Revoke(ctx context.Context, in *ChallengeRef, opts ...grpc.CallOption) (*RevokeResponse, error)
+	// RevokeUser revokes the challenges issued to a user by the replica that
+	// serves the call.
+	RevokeUser(ctx context.Context, in *RevokeUserRequest, opts ...grpc.CallOption) (*RevokeResponse, error)
+	CountChallenges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TierCounts, error)
+	// Collect removes the expired challenges from the stores that collect them.
+	Collect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CollectResponse, error)
+	// SetRateLimit changes a rate limit until the next restart or reload.
+	SetRateLimit(ctx context.Context, in *RateLimit, opts ...grpc.CallOption) (*RateLimit, error)
+}
+
+type captchaAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCaptchaAdminClient(cc grpc.ClientConnInterface) CaptchaAdminClient {
+	return &captchaAdminClient{cc}
+}
+
+func (c *captchaAdminClient) Lookup(ctx context.Context, in *ChallengeRef, opts ...grpc.CallOption) (*ChallengeInfo, error) {
+	out := new(ChallengeInfo)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/Lookup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captchaAdminClient) Revoke(ctx context.Context, in *ChallengeRef, opts ...grpc.CallOption) (*RevokeResponse, error) {
+	out := new(RevokeResponse)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/Revoke", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captchaAdminClient) RevokeUser(ctx context.Context, in *RevokeUserRequest, opts ...grpc.CallOption) (*RevokeResponse, error) {
+	out := new(RevokeResponse)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/RevokeUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captchaAdminClient) CountChallenges(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TierCounts, error) {
+	out := new(TierCounts)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/CountChallenges", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captchaAdminClient) Collect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CollectResponse, error) {
+	out := new(CollectResponse)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/Collect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *captchaAdminClient) SetRateLimit(ctx context.Context, in *RateLimit, opts ...grpc.CallOption) (*RateLimit, error) {
+	out := new(RateLimit)
+	err := c.cc.Invoke(ctx, "/api.CaptchaAdmin/SetRateLimit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CaptchaAdminServer is the server API for CaptchaAdmin service.
+// All implementations must embed UnimplementedCaptchaAdminServer
+// for forward compatibility
+type CaptchaAdminServer interface {
+	Lookup(context.Context, *ChallengeRef) (*ChallengeInfo, error)
+	Revoke(context.Context, *ChallengeRef) (*RevokeResponse, error)
+	// RevokeUser revokes the challenges issued to a user by the replica that
+	// serves the call.
+	RevokeUser(context.Context, *RevokeUserRequest) (*RevokeResponse, error)
+	CountChallenges(context.Context, *emptypb.Empty) (*TierCounts, error)
+	// Collect removes the expired challenges from the stores that collect them.
+	Collect(context.Context, *emptypb.Empty) (*CollectResponse, error)
+	// SetRateLimit changes a rate limit until the next restart or reload.
+	SetRateLimit(context.Context, *RateLimit) (*RateLimit, error)
+	mustEmbedUnimplementedCaptchaAdminServer()
+}
+
+// UnimplementedCaptchaAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedCaptchaAdminServer struct {
+}
+
+func (UnimplementedCaptchaAdminServer) Lookup(context.Context, *ChallengeRef) (*ChallengeInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedCaptchaAdminServer) Revoke(context.Context, *ChallengeRef) (*RevokeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Revoke not implemented")
+}
+func (UnimplementedCaptchaAdminServer) RevokeUser(context.Context, *RevokeUserRequest) (*RevokeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeUser not implemented")
+}
+func (UnimplementedCaptchaAdminServer) CountChallenges(context.Context, *emptypb.Empty) (*TierCounts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountChallenges not implemented")
+}
+func (UnimplementedCaptchaAdminServer) Collect(context.Context, *emptypb.Empty) (*CollectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Collect not implemented")
+}
+func (UnimplementedCaptchaAdminServer) SetRateLimit(context.Context, *RateLimit) (*RateLimit, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRateLimit not implemented")
+}
+func (UnimplementedCaptchaAdminServer) mustEmbedUnimplementedCaptchaAdminServer() {}
+
+// UnsafeCaptchaAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CaptchaAdminServer will
+// result in compilation errors.
+type UnsafeCaptchaAdminServer interface {
+	mustEmbedUnimplementedCaptchaAdminServer()
+}
+
+func RegisterCaptchaAdminServer(s grpc.ServiceRegistrar, srv CaptchaAdminServer) {
+	s.RegisterService(&CaptchaAdmin_ServiceDesc, srv)
+}
+
+func _CaptchaAdmin_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/Lookup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).Lookup(ctx, req.(*ChallengeRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptchaAdmin_Revoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).Revoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/Revoke",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).Revoke(ctx, req.(*ChallengeRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptchaAdmin_RevokeUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).RevokeUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/RevokeUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).RevokeUser(ctx, req.(*RevokeUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptchaAdmin_CountChallenges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).CountChallenges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/CountChallenges",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).CountChallenges(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptchaAdmin_Collect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).Collect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/Collect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).Collect(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CaptchaAdmin_SetRateLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateLimit)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CaptchaAdminServer).SetRateLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CaptchaAdmin/SetRateLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CaptchaAdminServer).SetRateLimit(ctx, req.(*RateLimit))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CaptchaAdmin_ServiceDesc is the grpc.ServiceDesc for CaptchaAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CaptchaAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.CaptchaAdmin",
+	HandlerType: (*CaptchaAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _CaptchaAdmin_Lookup_Handler,
+		},
+		{
+			MethodName: "Revoke",
+			Handler:    _CaptchaAdmin_Revoke_Handler,
+		},
+		{
+			MethodName: "RevokeUser",
+			Handler:    _CaptchaAdmin_RevokeUser_Handler,
+		},
+		{
+			MethodName: "CountChallenges",
+			Handler:    _CaptchaAdmin_CountChallenges_Handler,
+		},
+		{
+			MethodName: "Collect",
+			Handler:    _CaptchaAdmin_Collect_Handler,
+		},
+		{
+			MethodName: "SetRateLimit",
+			Handler:    _CaptchaAdmin_SetRateLimit_Handler,
+		},
+	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "captcha.proto3",
 }
@@ -1,41 +1,300 @@
-
 package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"github.com/roachapp/captcha/pkg/audit"
 	"github.com/roachapp/captcha/pkg/captcha"
+	"github.com/roachapp/captcha/pkg/config"
+	"github.com/roachapp/captcha/pkg/metrics"
 	"github.com/roachapp/captcha/pkg/store"
+	"github.com/roachapp/captcha/pkg/tracing"
+	"github.com/roachapp/captcha/pkg/util"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
 	"net"
-	"time"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	ip := "0.0.0.0"
-	port := 8666
-	ipPort := fmt.Sprintf(ip + ":%d", port)
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	setLogLevel(cfg.LogLevel)
 	ctx := context.Background()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Replicas must share the RNG keys to render the same image for a captcha.
+	if cfg.Captcha.RNGKeysFile != "" {
+		if err := util.LoadRNGKeysFile(cfg.Captcha.RNGKeysFile); err != nil {
+			log.Fatalf("failed to load rng keys: %v", err)
+		}
+	} else if cfg.Captcha.RNGKeys != "" {
+		if err := util.LoadRNGKeys(cfg.Captcha.RNGKeys); err != nil {
+			log.Fatalf("failed to load rng keys: %v", err)
+		}
+	} else {
+		log.Warn("no rng keys configured, images will differ between replicas and restarts")
+	}
+
+	// the memory tier is bounded unless the collect cache is configured
+	var cache store.Store
+	switch cfg.Store.Cache {
+	case config.CacheLRU:
+		capacity := (cfg.Store.Capacity + cfg.Store.Shards - 1) / cfg.Store.Shards
+		cache = store.NewShardedStore(cfg.Store.Shards, func() store.Store {
+			return store.NewLRUStore(capacity, cfg.Store.TTL, store.WithJanitor(cfg.Store.JanitorInterval))
+		})
+	case config.CacheCollect:
+		cache = store.NewCacheStore(cfg.Store.CollectNum, cfg.Store.TTL)
+	}
+
 	// create captcha generator
 	captchaGenerator := &captcha.Generator{
-		DigitLen: 3,
-		Width: 160,
-		Height: 80,
-		CacheStore: store.NewCacheStore(100, 30 * time.Second),
-		PgStore:    store.NewPostgresStore(ctx),
+		DigitLen:      cfg.Captcha.DigitLen,
+		Width:         cfg.Captcha.Width,
+		Height:        cfg.Captcha.Height,
+		PoWDifficulty: cfg.Captcha.PoWDifficulty,
+		TTL:           cfg.Store.TTL,
+		PoWTTL:        cfg.Store.PoWTTL,
+		AnonymousTTL:  cfg.Store.AnonymousTTL,
+		Secret:        []byte(cfg.Captcha.Secret),
+		CacheStore:    metrics.InstrumentStore("cache", cache),
+	}
+	// colors are checked by config.Validate
+	captchaGenerator.Color, _ = config.ParseColor(cfg.Captcha.Color)
+	switch cfg.Store.Backend {
+	case config.BackendPostgres:
+		s, err := store.NewPostgresStore(ctx, postgresConfig(cfg), cfg.Store.TTL)
+		if err != nil {
+			log.Fatalf("failed to open postgres store: %v", err)
+		}
+		captchaGenerator.PgStore = metrics.InstrumentStore("postgres", s)
+	case config.BackendBolt:
+		s, err := store.NewBoltStore(cfg.Store.BoltFile, cfg.Store.TTL, store.WithSweeper(cfg.Store.SweepInterval))
+		if err != nil {
+			log.Fatalf("failed to open bolt store: %v", err)
+		}
+		captchaGenerator.PgStore = metrics.InstrumentStore(config.BackendBolt, s)
+		captchaGenerator.PersistentTier = config.BackendBolt
+	}
+
+	var sinks []audit.Sink
+	if cfg.Audit.File != "" {
+		sink, err := audit.NewFileSink(cfg.Audit.File)
+		if err != nil {
+			log.Fatalf("failed to open audit file: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Audit.Postgres {
+		sink, err := audit.NewPostgresSink(ctx, postgresConfig(cfg))
+		if err != nil {
+			log.Fatalf("failed to set up the audit table: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) > 0 {
+		captchaGenerator.Audit = audit.Async(audit.Tee(sinks...), cfg.Audit.Buffer)
+	}
+
+	if len(captchaGenerator.Secret) == 0 {
+		log.Warn("no secret configured, using a random secret: captchas won't survive a restart")
+	}
+
+	// pre-render captchas so that Get doesn't wait for image generation
+	if cfg.Captcha.PoolSize > 0 {
+		captchaGenerator.Pool = captcha.NewPool(captchaGenerator, cfg.Captcha.PoolSize, cfg.Captcha.PoolWorkers)
+	}
+
+	// the gRPC and HTTP servers share a single rate limit
+	limiter := captcha.NewLimiter(cfg.RateLimit.Every, cfg.RateLimit.Burst)
+
+	// tenants get their own settings, rate limit and challenge namespace
+	var tenants *captcha.Tenants
+	if len(cfg.Tenants) > 0 {
+		tenants = captcha.NewTenants()
+		for _, tc := range cfg.Tenants {
+			g := captchaGenerator.ForTenant(tc.Name)
+			g.DigitLen = tc.DigitLen
+			g.Width = tc.Width
+			g.Height = tc.Height
+			g.PoWDifficulty = tc.PoWDifficulty
+			g.TTL = tc.TTL
+			g.PoWTTL = tc.PoWTTL
+			g.AnonymousTTL = tc.AnonymousTTL
+			g.Color, _ = config.ParseColor(tc.Color)
+			if tc.PoolSize > 0 {
+				g.Pool = captcha.NewPool(g, tc.PoolSize, cfg.Captcha.PoolWorkers)
+			}
+			t := &captcha.Tenant{
+				Name:      tc.Name,
+				Generator: g,
+				Limiter:   captcha.NewLimiter(tc.RateLimit.Every, tc.RateLimit.Burst),
+			}
+			if err := tenants.Add(t, tc.APIKeys...); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Infof("Serving %d tenants", len(cfg.Tenants))
+	}
+	go reloadOnSIGHUP(limiter, tenants)
+
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Infof("Metrics available on %s/metrics", cfg.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				log.Fatal(err)
+			}
+		}()
 	}
 
 	// grpc connection
-	conn, err := net.Listen("tcp", ipPort)
+	conn, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := captcha.NewServer(ctx, captchaGenerator)
-	log.Infof("Captcha Server running on %s", ipPort)
+	healthServer := health.NewServer()
+	opts := []captcha.ServerOption{captcha.WithLimiter(limiter), captcha.WithHealth(healthServer)}
+	if cfg.Reflection {
+		opts = append(opts, captcha.WithReflection())
+	}
+	if tenants != nil {
+		opts = append(opts, captcha.WithTenants(tenants))
+	}
+	if cfg.Admin.Enabled {
+		opts = append(opts, captcha.WithAdmin(cfg.Admin.Key, cfg.Admin.ClientNames...))
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled() {
+		if tlsConfig, err = captcha.ServerTLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile); err != nil {
+			log.Fatalf("failed to load tls configuration: %v", err)
+		}
+		opts = append(opts, captcha.WithGRPCOptions(grpc.Creds(credentials.NewTLS(tlsConfig))))
+		// only backends with a client certificate can validate solutions
+		if cfg.TLS.ClientCAFile != "" {
+			opts = append(opts, captcha.WithClientAuth(captcha.PrivilegedMethods...))
+		}
+	}
+	svc := &captcha.Service{
+		Generator: captchaGenerator,
+		Tenants:   tenants,
+		GRPC:      captcha.NewServer(ctx, captchaGenerator, opts...),
+		Health:    healthServer,
+		// outstanding challenges survive deployments without postgres
+		SnapshotFile: cfg.Store.SnapshotFile,
+		SnapshotKey:  []byte(cfg.Store.SnapshotKey),
+	}
+	log.Infof("Captcha Server running on %s", cfg.GRPCAddr)
+
+	var httpConn net.Listener
+	if cfg.HTTPAddr != "" {
+		if httpConn, err = net.Listen("tcp", cfg.HTTPAddr); err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		// same TLS, rate limit and client authentication as the gRPC server,
+		// so that backends can validate with their client certificate
+		if tlsConfig != nil {
+			httpConn = tls.NewListener(httpConn, tlsConfig)
+		}
+		svc.HTTP = &http.Server{Handler: captcha.NewHTTPHandler(ctx, captchaGenerator, opts...)}
+		log.Infof("Captcha HTTP Server running on %s", cfg.HTTPAddr)
+	}
+
+	runErr := svc.Run(shutdownContext(), conn, httpConn)
 
-	if err := grpcServer.Serve(conn); err != nil {
-		log.Fatal(err)
+	flushCtx, cancel := context.WithTimeout(context.Background(), captcha.DefaultShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Errorf("failed to flush traces: %v", err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+	log.Info("stopped")
+}
+
+// shutdownContext returns a context that is cancelled on SIGINT or SIGTERM.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Infof("received %s", <-sig)
+		signal.Stop(sig)
+		cancel()
+	}()
+	return ctx
+}
+
+func setLogLevel(level string) {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		log.Errorf("invalid log level %q: %v", level, err)
+		return
+	}
+	log.SetLevel(l)
+}
+
+// reloadOnSIGHUP reloads the configuration on SIGHUP and applies the settings
+// that can change at runtime: the rate limits, including the tenants', and
+// the log level. Everything else, like adding tenants, needs a restart.
+func reloadOnSIGHUP(limiter *captcha.Limiter, tenants *captcha.Tenants) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := config.Load(os.Args[1:])
+		if err != nil {
+			log.Errorf("failed to reload configuration, keeping the current one: %v", err)
+			continue
+		}
+		setLogLevel(cfg.LogLevel)
+		limiter.SetRate(cfg.RateLimit.Every, cfg.RateLimit.Burst)
+		for _, tc := range cfg.Tenants {
+			if tenants == nil {
+				break
+			}
+			if t := tenants.Get(tc.Name); t != nil {
+				t.Limiter.SetRate(tc.RateLimit.Every, tc.RateLimit.Burst)
+			}
+		}
+		log.Infof("configuration reloaded: log level %s, rate limit %d every %s",
+			cfg.LogLevel, cfg.RateLimit.Burst, cfg.RateLimit.Every)
+	}
+}
+
+// postgresConfig returns the settings of the connections to the database of
+// the postgres store and audit sink.
+func postgresConfig(cfg *config.Config) store.PostgresConfig {
+	pool := cfg.Store.Pool
+	return store.PostgresConfig{
+		URL:                    cfg.Store.DatabaseURL,
+		MaxConns:               int32(pool.MaxConns),
+		MinConns:               int32(pool.MinConns),
+		MaxConnLifetime:        pool.MaxConnLifetime,
+		MaxConnIdleTime:        pool.MaxConnIdleTime,
+		HealthCheckPeriod:      pool.HealthCheckPeriod,
+		StatementCacheCapacity: pool.StatementCache,
+		ConnectAttempts:        pool.ConnectAttempts,
+		ConnectBackoff:         pool.ConnectBackoff,
+		SweepInterval:          cfg.Store.SweepInterval,
 	}
 }
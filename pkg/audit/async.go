@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// async records events in a sink from a background goroutine.
+type async struct {
+	sink   Sink
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Async returns a sink that queues up to size events, and records them in s
+// from a background goroutine, so that a slow sink doesn't slow down the
+// requests. Events that don't fit in the queue are dropped and counted in
+// metrics.AuditEventsDropped. Close records the queued events before closing
+// s. Failures of s are logged.
+func Async(s Sink, size int) Sink {
+	a := &async{
+		sink:   s,
+		events: make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+	go a.write()
+	return a
+}
+
+// Record queues the event, or drops it if the queue is full or the sink is
+// closed. It never fails.
+func (a *async) Record(_ context.Context, e Event) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		metrics.AuditEventsDropped.Inc()
+		return nil
+	}
+	select {
+	case a.events <- e:
+	default:
+		metrics.AuditEventsDropped.Inc()
+	}
+	return nil
+}
+
+func (a *async) write() {
+	defer close(a.done)
+	for e := range a.events {
+		// The request of the event is likely over already.
+		if err := a.sink.Record(context.Background(), e); err != nil {
+			log.Errorf("audit: failed to record %s event of %q: %v", e.Kind, e.ChallengeID, err)
+		}
+	}
+}
+
+// Close records the queued events, and closes the sink.
+func (a *async) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return a.sink.Close()
+}
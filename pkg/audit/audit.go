@@ -0,0 +1,78 @@
+// Package audit records the lifecycle of challenges for abuse investigations.
+//
+// Every challenge produces an issue event, then any number of render and
+// reload events and validate events, all carrying the challenge id so that
+// attempts can be joined to their issuance, and possibly a revoke event from
+// the admin service. Solutions are never recorded.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Kinds of events.
+const (
+	KindIssue    = "issue"
+	KindRender   = "render"
+	KindReload   = "reload"
+	KindValidate = "validate"
+	KindRevoke   = "revoke"
+)
+
+// Event is an entry of the audit trail.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"event"`
+	// Tenant is the name of the tenant of the challenge, if any.
+	Tenant string `json:"tenant,omitempty"`
+	// ChallengeID is empty for validations rejected before the request was
+	// read, like the rate-limited ones of the HTTP server.
+	ChallengeID string `json:"challenge_id,omitempty"`
+	// User is the id sent by the client with its request, if any.
+	User       string `json:"user,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	// Outcome of renders, reloads, validations and revocations, like "ok" or
+	// "not_found".
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Sink records audit events. Implementations must be safe for concurrent use.
+type Sink interface {
+	// Record appends the event to the audit trail.
+	Record(ctx context.Context, e Event) error
+
+	// Close flushes and closes the sink.
+	Close() error
+}
+
+// tee records events in several sinks.
+type tee []Sink
+
+// Tee returns a sink that records events in all the given sinks.
+func Tee(sinks ...Sink) Sink {
+	return tee(sinks)
+}
+
+func (t tee) Record(ctx context.Context, e Event) error {
+	var err error
+	for _, s := range t {
+		if serr := s.Record(ctx, e); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (t tee) Close() error {
+	var err error
+	for _, s := range t {
+		if serr := s.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
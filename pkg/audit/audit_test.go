@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.jsonl")
+
+	events := []Event{
+		{Time: time.Unix(1, 0).UTC(), Kind: KindIssue, ChallengeID: "a", Type: "image", Peer: "192.0.2.1"},
+		{Time: time.Unix(2, 0).UTC(), Kind: KindValidate, ChallengeID: "a", Outcome: "ok"},
+	}
+	// Records must be appended across reopenings.
+	for _, e := range events {
+		s, err := NewFileSink(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Tee(s).Record(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var got []Event
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, expected %d", len(got), len(events))
+	}
+	for i := range events {
+		if got[i] != events[i] {
+			t.Errorf("event %d: got %+v, expected %+v", i, got[i], events[i])
+		}
+	}
+}
+
+// blockingSink records events once it's released, and signals when it starts
+// recording one.
+type blockingSink struct {
+	started  chan struct{}
+	release  chan struct{}
+	recorded []Event
+	closed   bool
+}
+
+func (s *blockingSink) Record(ctx context.Context, e Event) error {
+	s.started <- struct{}{}
+	<-s.release
+	s.recorded = append(s.recorded, e)
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAsync(t *testing.T) {
+	s := &blockingSink{started: make(chan struct{}, 10), release: make(chan struct{})}
+	a := Async(s, 2)
+	ctx := context.Background()
+	dropped := testutil.ToFloat64(metrics.AuditEventsDropped)
+
+	// The first event is being recorded, the next two are queued and the
+	// last one is dropped, without waiting for the sink.
+	a.Record(ctx, Event{ChallengeID: "a"})
+	<-s.started
+	for _, id := range []string{"b", "c", "d"} {
+		if err := a.Record(ctx, Event{ChallengeID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := testutil.ToFloat64(metrics.AuditEventsDropped); n != dropped+1 {
+		t.Errorf("got %v dropped events, expected %v", n, dropped+1)
+	}
+
+	close(s.release)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.recorded) != 3 || s.recorded[2].ChallengeID != "c" || !s.closed {
+		t.Errorf("queue not drained on close: %+v, closed %v", s.recorded, s.closed)
+	}
+	a.Record(ctx, Event{ChallengeID: "e"})
+	if n := testutil.ToFloat64(metrics.AuditEventsDropped); n != dropped+2 {
+		t.Errorf("event recorded after close")
+	}
+}
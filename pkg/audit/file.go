@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// fileSink writes events as JSON lines.
+type fileSink struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileSink returns a sink that appends events to the named file, one JSON
+// object per line. The file is created if it doesn't exist.
+func NewFileSink(name string) (Sink, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *fileSink) Record(ctx context.Context, e Event) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.enc.Encode(e)
+}
+
+func (s *fileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/roachapp/captcha/pkg/store"
+	"time"
+)
+
+// Schema creates the table written by the Postgres sink. Attempts are joined
+// to their issuance on challenge_id:
+//
+//   SELECT i.peer, v.peer, v.outcome
+//   FROM captcha_audit i JOIN captcha_audit v USING (challenge_id)
+//   WHERE i.event = 'issue' AND v.event = 'validate';
+const Schema = `CREATE TABLE IF NOT EXISTS captcha_audit (
+	seq          BIGSERIAL PRIMARY KEY,
+	time         TIMESTAMPTZ NOT NULL,
+	event        TEXT NOT NULL,
+	challenge_id TEXT,
+	user_id      TEXT,
+	peer         TEXT,
+	user_agent   TEXT,
+	type         TEXT,
+	difficulty   INTEGER,
+	outcome      TEXT
+);
+ALTER TABLE captcha_audit ADD COLUMN IF NOT EXISTS tenant TEXT;
+CREATE INDEX IF NOT EXISTS captcha_audit_challenge_id ON captcha_audit (challenge_id);
+CREATE INDEX IF NOT EXISTS captcha_audit_time ON captcha_audit (time);`
+
+const insertEvent = `INSERT INTO captcha_audit
+	(time, event, challenge_id, user_id, peer, user_agent, type, difficulty, outcome, tenant)
+	VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, 0), NULLIF($9, ''), NULLIF($10, ''));`
+
+// recordTimeout bounds the insertion of an event, independently of the
+// request it belongs to, which may already be cancelled.
+const recordTimeout = 5 * time.Second
+
+// postgresSink inserts events in the captcha_audit table.
+type postgresSink struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresSink returns a sink that inserts events in the captcha_audit
+// table of the database of cfg, creating it with Schema if needed. Its pool
+// has the settings of cfg, and connecting is retried like for the postgres
+// store.
+func NewPostgresSink(ctx context.Context, cfg store.PostgresConfig) (Sink, error) {
+	pool, err := store.ConnectPostgres(ctx, cfg, Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresSink{pool: pool}, nil
+}
+
+// Record ignores the request's context, so that events of cancelled requests
+// are recorded too.
+func (s *postgresSink) Record(_ context.Context, e Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+	_, err := s.pool.Exec(ctx, insertEvent,
+		e.Time, e.Kind, e.ChallengeID, e.User, e.Peer, e.UserAgent, e.Type, e.Difficulty, e.Outcome, e.Tenant)
+	return err
+}
+
+func (s *postgresSink) Close() error {
+	s.pool.Close()
+	return nil
+}
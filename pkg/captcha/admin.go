@@ -0,0 +1,316 @@
+package captcha
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/roachapp/captcha/pkg/audit"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"github.com/roachapp/captcha/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// AdminKeyHeader is the gRPC metadata key carrying the admin key.
+const AdminKeyHeader = "x-admin-key"
+
+// userRetention is how long the challenges issued to a user are remembered
+// for RevokeUser. It's longer than any sensible TTL.
+var userRetention = time.Hour
+
+// userSweepEvery is the number of tracked challenges between sweeps of the
+// users whose challenges are all older than userRetention.
+const userSweepEvery = 1000
+
+// userIndex remembers the ids of the challenges issued to each user, with
+// their issue time, so that they can be revoked together. It only knows the
+// challenges issued by this process.
+type userIndex struct {
+	sync.Mutex
+	ids     map[string]map[string]time.Time
+	tracked int
+}
+
+// track records that the challenge with the given id was issued to the user.
+func (ix *userIndex) track(user, id string) {
+	now := time.Now()
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.ids == nil {
+		ix.ids = make(map[string]map[string]time.Time)
+	}
+	if ix.ids[user] == nil {
+		ix.ids[user] = make(map[string]time.Time)
+	}
+	ix.ids[user][id] = now
+
+	if ix.tracked++; ix.tracked%userSweepEvery != 0 {
+		ix.prune(user, now)
+		return
+	}
+	for u := range ix.ids {
+		ix.prune(u, now)
+	}
+}
+
+// prune forgets the challenges of the user older than userRetention.
+func (ix *userIndex) prune(user string, now time.Time) {
+	for id, issued := range ix.ids[user] {
+		if now.Sub(issued) > userRetention {
+			delete(ix.ids[user], id)
+		}
+	}
+	if len(ix.ids[user]) == 0 {
+		delete(ix.ids, user)
+	}
+}
+
+// take returns and forgets the ids of the challenges issued to the user.
+func (ix *userIndex) take(user string) []string {
+	ix.Lock()
+	defer ix.Unlock()
+	ids := make([]string, 0, len(ix.ids[user]))
+	for id := range ix.ids[user] {
+		ids = append(ids, id)
+	}
+	delete(ix.ids, user)
+	return ids
+}
+
+// tier is a store of the generator with its name in metrics and traces.
+type tier struct {
+	name  string
+	store store.Store
+}
+
+// tiers returns the stores of the generator, fastest first.
+func (g *Generator) tiers() []tier {
+	tiers := []tier{{cacheTier, g.CacheStore}}
+	if g.PgStore != nil {
+		tiers = append(tiers, tier{g.persistentTier(), g.PgStore})
+	}
+	return tiers
+}
+
+// revoke removes the challenge from the stores and returns true if it existed.
+func (g *Generator) revoke(ctx context.Context, id, user string) bool {
+	rec, _ := g.get(ctx, id, true)
+	ok := rec != nil
+	outcome := metrics.OutcomeOK
+	if !ok {
+		outcome = metrics.OutcomeNotFound
+	}
+	g.audit(ctx, audit.Event{Kind: audit.KindRevoke, ChallengeID: id, User: user, Outcome: outcome})
+	return ok
+}
+
+// WithAdmin registers the CaptchaAdmin service on the gRPC server created by
+// NewServer. Its calls need the given key in the AdminKeyHeader metadata if
+// it's not empty, or a client certificate verified by the server's client CAs
+// with one of the given subject common names. Other certificates of the same
+// CAs, like the ones of the backends allowed to validate, are refused.
+// NewHTTPHandler ignores it.
+func WithAdmin(key string, clientNames ...string) ServerOption {
+	return func(o *serverOptions) {
+		o.admin = true
+		o.adminKey = key
+		o.adminClients = make(map[string]bool)
+		for _, name := range clientNames {
+			o.adminClients[name] = true
+		}
+	}
+}
+
+// adminMethod returns true if the full gRPC method name belongs to the
+// CaptchaAdmin service.
+func adminMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.CaptchaAdmin_ServiceDesc.ServiceName+"/")
+}
+
+// adminAuthInterceptor rejects the CaptchaAdmin calls of clients without the
+// admin key or a verified certificate of one of the clients.
+func adminAuthInterceptor(key string, clients map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !adminMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if name, ok := verifiedClientName(ctx); ok && clients[name] {
+			return handler(ctx, req)
+		}
+		var given string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(AdminKeyHeader); len(v) > 0 {
+				given = v[0]
+			}
+		}
+		if key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "an admin client certificate or the admin key is required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+type adminServer struct {
+	pb.UnimplementedCaptchaAdminServer
+	capGen  *Generator
+	tenants *Tenants
+	limiter *Limiter
+}
+
+// tenant returns the named tenant, nil for the empty name, or a NotFound
+// error.
+func (a adminServer) tenant(name string) (*Tenant, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if a.tenants != nil {
+		if t := a.tenants.Get(name); t != nil {
+			return t, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "unknown tenant %q", name)
+}
+
+// generator returns the generator of the named tenant, or the server's for
+// the empty name.
+func (a adminServer) generator(tenant string) (*Generator, error) {
+	t, err := a.tenant(tenant)
+	if err != nil || t == nil {
+		return a.capGen, err
+	}
+	return t.Generator, nil
+}
+
+// Lookup returns the metadata of a challenge. Its solution is only returned
+// on request, and the disclosure is logged.
+func (a adminServer) Lookup(ctx context.Context, ref *pb.ChallengeRef) (*pb.ChallengeInfo, error) {
+	g, err := a.generator(ref.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	info := &pb.ChallengeInfo{Id: ref.Id, Tenant: ref.Tenant}
+	var rec []byte
+	for _, t := range g.tiers() {
+		if r, expiresAt := storeGet(ctx, t.name, t.store, g.storeKey(ref.Id), false); r != nil {
+			info.Tiers = append(info.Tiers, t.name)
+			if rec == nil {
+				rec = r
+				info.ExpiresAt = timestamppb.New(expiresAt)
+			}
+		}
+	}
+	if rec == nil {
+		return nil, status.Errorf(codes.NotFound, "challenge %q not found", ref.Id)
+	}
+
+	switch {
+	case len(rec) >= 2 && rec[0] == powTag:
+		info.Type = pb.ChallengeType_PROOF_OF_WORK
+		info.Difficulty = int32(rec[1])
+	default:
+		info.Type = pb.ChallengeType_IMAGE
+		digits, keyID := g.open(ref.Id, rec)
+		info.Length = int32(len(digits))
+		info.RngKeyId = keyID
+		if ref.IncludeSolution && digits != nil {
+			log.Warnf("admin: solution of challenge %q disclosed to %s", ref.Id, clientKey(ctx, ""))
+			s := make([]byte, len(digits))
+			for i, d := range digits {
+				s[i] = '0' + d
+			}
+			info.Solution = string(s)
+		}
+	}
+	return info, nil
+}
+
+// Revoke removes a challenge from the stores, so that it can't be rendered or
+// validated anymore.
+func (a adminServer) Revoke(ctx context.Context, ref *pb.ChallengeRef) (*pb.RevokeResponse, error) {
+	g, err := a.generator(ref.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	if !g.revoke(ctx, ref.Id, "") {
+		return nil, status.Errorf(codes.NotFound, "challenge %q not found", ref.Id)
+	}
+	return &pb.RevokeResponse{Revoked: 1}, nil
+}
+
+// RevokeUser revokes the challenges issued to a user by this replica.
+func (a adminServer) RevokeUser(ctx context.Context, req *pb.RevokeUserRequest) (*pb.RevokeResponse, error) {
+	if req.User == "" {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+	g, err := a.generator(req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(pb.RevokeResponse)
+	for _, id := range g.users.take(req.User) {
+		if g.revoke(ctx, id, req.User) {
+			resp.Revoked++
+		}
+	}
+	return resp, nil
+}
+
+// CountChallenges returns the number of challenges of every tier. Tenants
+// share the stores, so the counts include all of them.
+func (a adminServer) CountChallenges(ctx context.Context, _ *emptypb.Empty) (*pb.TierCounts, error) {
+	resp := new(pb.TierCounts)
+	for _, t := range a.capGen.tiers() {
+		count := int64(-1)
+		if n, ok := store.Len(t.store); ok {
+			count = int64(n)
+		}
+		resp.Tiers = append(resp.Tiers, &pb.TierCount{Tier: t.name, Count: count})
+	}
+	return resp, nil
+}
+
+// Collect runs a collection of the tiers that collect expired challenges.
+func (a adminServer) Collect(ctx context.Context, _ *emptypb.Empty) (*pb.CollectResponse, error) {
+	resp := new(pb.CollectResponse)
+	for _, t := range a.capGen.tiers() {
+		if store.Collect(t.store) {
+			resp.Tiers = append(resp.Tiers, t.name)
+		}
+	}
+	return resp, nil
+}
+
+// SetRateLimit changes the rate limit of the server or of a tenant, until it's
+// changed again or the configuration is reloaded.
+func (a adminServer) SetRateLimit(ctx context.Context, req *pb.RateLimit) (*pb.RateLimit, error) {
+	every := req.Every.AsDuration()
+	if every <= 0 || req.Burst <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "every and burst must be positive")
+	}
+	t, err := a.tenant(req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	l := a.limiter
+	if t != nil {
+		if t.Limiter == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "tenant %q uses the server's rate limit", t.Name)
+		}
+		l = t.Limiter
+	}
+	l.SetRate(every, int(req.Burst))
+	log.Infof("admin: rate limit of %q set to %d every %s by %s", req.Tenant, req.Burst, every, clientKey(ctx, ""))
+
+	every, burst := l.Rate()
+	return &pb.RateLimit{Tenant: req.Tenant, Every: durationpb.New(every), Burst: int32(burst)}, nil
+}
@@ -0,0 +1,143 @@
+package captcha
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// dialServer serves srv on an in-memory listener and returns a connection to
+// it.
+func dialServer(t *testing.T, srv *grpc.Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestAdminAuth(t *testing.T) {
+	conn := dialServer(t, NewServer(context.Background(), DefaultGenerator(), WithAdmin("secret")))
+	admin := pb.NewCaptchaAdminClient(conn)
+	ctx := context.Background()
+	for key, want := range map[string]codes.Code{
+		"":       codes.Unauthenticated,
+		"wrong":  codes.Unauthenticated,
+		"secret": codes.OK,
+	} {
+		_, err := admin.CountChallenges(metadata.AppendToOutgoingContext(ctx, AdminKeyHeader, key), new(emptypb.Empty))
+		if status.Code(err) != want {
+			t.Errorf("key %q: expected %s, got %v", key, want, err)
+		}
+	}
+
+	conn = dialServer(t, NewServer(ctx, DefaultGenerator()))
+	_, err := pb.NewCaptchaAdminClient(conn).CountChallenges(ctx, new(emptypb.Empty))
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("admin service registered without WithAdmin: %v", err)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	g := DefaultGenerator()
+	ts, shop, _ := testTenants(t, g)
+	conn := dialServer(t, NewServer(context.Background(), g, WithTenants(ts), WithAdmin("secret")))
+	client, admin := pb.NewCaptchaClient(conn), pb.NewCaptchaAdminClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AdminKeyHeader, "secret", APIKeyHeader, "shop-1")
+
+	var ids []string
+	for _, user := range []string{"mallory", "mallory", "alice"} {
+		c, err := client.Get(ctx, &pb.User{Id: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, c.Id)
+	}
+
+	info, err := admin.Lookup(ctx, &pb.ChallengeRef{Id: ids[2], Tenant: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != pb.ChallengeType_IMAGE || info.Length != 5 || info.Solution != "" ||
+		!reflect.DeepEqual(info.Tiers, []string{cacheTier, pgTier}) {
+		t.Errorf("unexpected info %v", info)
+	}
+	info, err = admin.Lookup(ctx, &pb.ChallengeRef{Id: ids[2], Tenant: "shop", IncludeSolution: true})
+	if want := solutionString(shop.Generator.digits(ctx, ids[2])); err != nil || info.Solution != want {
+		t.Errorf("expected solution %q, got %v %v", want, info, err)
+	}
+	if _, err := admin.Lookup(ctx, &pb.ChallengeRef{Id: ids[2]}); status.Code(err) != codes.NotFound {
+		t.Errorf("challenge of the shop found without its tenant: %v", err)
+	}
+	if _, err := admin.Lookup(ctx, &pb.ChallengeRef{Id: ids[2], Tenant: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown tenant: expected NotFound, got %v", err)
+	}
+
+	if r, err := admin.Revoke(ctx, &pb.ChallengeRef{Id: ids[2], Tenant: "shop"}); err != nil || r.Revoked != 1 {
+		t.Errorf("Revoke: %v %v", r, err)
+	}
+	if _, err := admin.Revoke(ctx, &pb.ChallengeRef{Id: ids[2], Tenant: "shop"}); status.Code(err) != codes.NotFound {
+		t.Errorf("revoked twice: %v", err)
+	}
+	if r, err := admin.RevokeUser(ctx, &pb.RevokeUserRequest{User: "mallory", Tenant: "shop"}); err != nil || r.Revoked != 2 {
+		t.Errorf("RevokeUser: %v %v", r, err)
+	}
+	if shop.Generator.digits(ctx, ids[0]) != nil || shop.Generator.digits(ctx, ids[1]) != nil {
+		t.Errorf("challenges of the user not revoked")
+	}
+
+	g.New(ctx)
+	counts, err := admin.CountChallenges(ctx, new(emptypb.Empty))
+	if err != nil || len(counts.Tiers) != 2 || counts.Tiers[0].Tier != cacheTier || counts.Tiers[0].Count != 1 {
+		t.Errorf("CountChallenges: %v %v", counts, err)
+	}
+	if r, err := admin.Collect(ctx, new(emptypb.Empty)); err != nil || len(r.Tiers) != 2 {
+		t.Errorf("Collect: %v %v", r, err)
+	}
+
+	rl, err := admin.SetRateLimit(ctx, &pb.RateLimit{Tenant: "shop", Every: durationpb.New(time.Minute), Burst: 10})
+	if err != nil || rl.Every.AsDuration() != time.Minute || rl.Burst != 10 {
+		t.Errorf("SetRateLimit: %v %v", rl, err)
+	}
+	if every, burst := shop.Limiter.Rate(); every != time.Minute || burst != 10 {
+		t.Errorf("limiter of the shop not changed: %s %d", every, burst)
+	}
+	if _, err := admin.SetRateLimit(ctx, &pb.RateLimit{Every: durationpb.New(0), Burst: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("zero rate: expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestAdminNotRateLimited(t *testing.T) {
+	limiter := NewLimiter(time.Hour, 1)
+	conn := dialServer(t, NewServer(context.Background(), DefaultGenerator(), WithLimiter(limiter), WithAdmin("secret")))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AdminKeyHeader, "secret")
+	client, admin := pb.NewCaptchaClient(conn), pb.NewCaptchaAdminClient(conn)
+	client.Get(ctx, &pb.User{})
+	if _, err := client.Get(ctx, &pb.User{}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the limit to be exceeded, got %v", err)
+	}
+	if _, err := admin.SetRateLimit(ctx, &pb.RateLimit{Every: durationpb.New(time.Millisecond), Burst: 10}); err != nil {
+		t.Fatalf("admin call limited: %v", err)
+	}
+	if every, burst := limiter.Rate(); every != time.Millisecond || burst != 10 {
+		t.Errorf("limiter not changed: %s %d", every, burst)
+	}
+}
@@ -0,0 +1,63 @@
+package captcha
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/audit"
+	"github.com/roachapp/captcha/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// audit records the event in the generator's Audit sink, if any, with the
+// time, the tenant and the address and user agent of the client of ctx.
+// Failures are logged, they don't fail the request.
+func (g *Generator) audit(ctx context.Context, e audit.Event) {
+	if g.Audit == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Tenant = g.Tenant
+	e.Peer = clientKey(ctx, "")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			e.UserAgent = ua[0]
+		}
+	}
+	if err := g.Audit.Record(ctx, e); err != nil {
+		log.Errorf("audit: failed to record %s event of %q: %v", e.Kind, e.ChallengeID, err)
+	}
+}
+
+// auditRateLimitInterceptor records the validations rejected by the rate
+// limit, which never reach the Generator, in the trail of g or of their
+// tenant's generator. It must run before the rate limit.
+func auditRateLimitInterceptor(g *Generator) grpc.UnaryServerInterceptor {
+	srv := captchaServer{capGen: g}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if sol, ok := req.(*pb.Solution); ok && status.Code(err) == codes.ResourceExhausted {
+			srv.generator(ctx).audit(ctx, audit.Event{
+				Kind:        audit.KindValidate,
+				ChallengeID: sol.Id,
+				Type:        challengeType(sol.Type),
+				Outcome:     metrics.OutcomeRateLimited,
+			})
+		}
+		return resp, err
+	}
+}
+
+// challengeType returns the name of a challenge type in metrics and audit
+// events.
+func challengeType(t pb.ChallengeType) string {
+	if t == pb.ChallengeType_PROOF_OF_WORK {
+		return powType
+	}
+	return imageType
+}
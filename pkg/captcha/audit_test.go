@@ -0,0 +1,111 @@
+package captcha
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// memorySink keeps the recorded events.
+type memorySink struct {
+	sync.Mutex
+	events []audit.Event
+}
+
+func (s *memorySink) Record(ctx context.Context, e audit.Event) error {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	sink := new(memorySink)
+	g.Audit = sink
+	h := NewHTTPHandler(ctx, g, WithLimiter(NewLimiter(time.Hour, 4)))
+
+	request := func(method, path, body string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("User-Agent", "audit-test")
+		r.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	request(http.MethodPost, HTTPPrefix+"new", `{"id":"user-1"}`)
+	if len(sink.events) != 1 {
+		t.Fatalf("expected an issue event, got %+v", sink.events)
+	}
+	id := sink.events[0].ChallengeID
+	request(http.MethodGet, HTTPPrefix+id+".png?reload=1", "")
+	code := solutionString(g.digits(ctx, id))
+	for i := 0; i < 3; i++ {
+		request(http.MethodPost, HTTPPrefix+"validate", `{"id":"`+id+`","code":"`+code+`"}`)
+	}
+
+	want := []audit.Event{
+		{Kind: audit.KindIssue, User: "user-1", Type: imageType},
+		{Kind: audit.KindReload, Outcome: "ok"},
+		{Kind: audit.KindRender, Outcome: "ok"},
+		{Kind: audit.KindValidate, Type: imageType, Outcome: "ok"},
+		{Kind: audit.KindValidate, Type: imageType, Outcome: "not_found"},
+		{Kind: audit.KindValidate, Outcome: "rate_limited"},
+	}
+	if len(sink.events) != len(want) {
+		t.Fatalf("got %d events, expected %d: %+v", len(sink.events), len(want), sink.events)
+	}
+	for i, e := range sink.events {
+		if e.ChallengeID == code || e.User == code {
+			t.Errorf("event %d records the solution: %+v", i, e)
+		}
+		if e.Time.IsZero() || e.Peer != "192.0.2.1" || e.UserAgent != "audit-test" {
+			t.Errorf("event %d: missing time or client: %+v", i, e)
+		}
+		w := want[i]
+		w.Time, w.Peer, w.UserAgent = e.Time, e.Peer, e.UserAgent
+		if w.Outcome != "rate_limited" {
+			w.ChallengeID = id
+		}
+		if e != w {
+			t.Errorf("event %d: got %+v, expected %+v", i, e, w)
+		}
+	}
+}
+
+func TestAuditRateLimitedRPC(t *testing.T) {
+	g := DefaultGenerator()
+	sink := new(memorySink)
+	g.Audit = sink
+	interceptor := auditRateLimitInterceptor(g)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Captcha/Validate"}
+	limited := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.ResourceExhausted, "limited")
+	}
+
+	req := &pb.Solution{Id: "id", Code: "123", Type: pb.ChallengeType_PROOF_OF_WORK}
+	interceptor(context.Background(), req, info, limited)
+	if len(sink.events) != 1 {
+		t.Fatalf("got %d events, expected 1", len(sink.events))
+	}
+	e := sink.events[0]
+	if e.Kind != audit.KindValidate || e.ChallengeID != "id" || e.Type != powType || e.Outcome != "rate_limited" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	interceptor(context.Background(), &pb.User{}, info, limited)
+	if len(sink.events) != 1 {
+		t.Errorf("non-validation requests audited: %+v", sink.events[1:])
+	}
+}
@@ -2,56 +2,46 @@
 // Use of this source code is governed by a MIT-style
 // license that can be found in the LICENSE file.
 
-// Package captcha implements generation and verification of image and audio
-// CAPTCHAs.
+// Package captcha implements a service issuing and validating CAPTCHAs.
 //
-// A captcha solution is the sequence of digits 0-9 with the defined length.
-// There are two captcha representations: image and audio.
+// A Generator issues two kinds of challenges. An image captcha is a sequence
+// of digits 0-9 printed on a PNG image in a way that makes it hard for
+// computers to read it using OCR. A proof-of-work challenge asks the client
+// to find a nonce whose hash with a prefix has a number of leading zero bits,
+// harder for clients that request too many or keep failing. Audio captchas
+// aren't served: this fork doesn't ship the voice samples they need.
 //
-// An image representation is a PNG-encoded image with the solution printed on
-// it in such a way that makes it hard for computers to solve it using OCR.
+// Challenges are one-time: their sealed solutions are kept in a memory store
+// and, optionally, a persistent one (see the store package) until they are
+// validated or expire. Images are rendered on the fly by WriteImage, with
+// the same result for the same id, or ahead of time by a Pool. Reload gives
+// a captcha a new solution without extending its lifetime.
 //
-// An audio representation is a WAVE-encoded (8 kHz unsigned 8-bit) sound with
-// the spoken solution (currently in English, Russian, Chinese, and Japanese).
-// To make it hard for computers to solve audio captcha, the voice that
-// pronounces numbers has random speed and pitch, and there is a randomly
-// generated background noise mixed into the sound.
-//
-// This package doesn't require external files or libraries to generate captcha
-// representations; it is self-contained.
-//
-// To make captchas one-time, the package includes a memory storage that stores
-// captcha ids, their solutions, and expiration time. Used captchas are removed
-// from the store immediately after calling Verify or VerifyString, while
-// unused captchas (user loaded a page with captcha, but didn't submit the
-// form) are collected automatically after the predefined expiration time.
-// Developers can also provide custom store (for example, which saves captcha
-// ids and solutions in database) by implementing Store interface and
-// registering the object with SetCustomStore.
-//
-// Captchas are created by calling New, which returns the captcha id.  Their
-// representations, though, are created on-the-fly by calling WriteImage or
-// WriteAudio functions. Created representations are not stored anywhere, but
-// subsequent calls to these functions with the same id will write the same
-// captcha solution. Reload function will create a new different solution for
-// the provided captcha, allowing users to "reload" captcha if they can't solve
-// the displayed one without reloading the whole page.  Verify and VerifyString
-// are used to verify that the given solution is the right one for the given
-// captcha id.
-//
-// Server provides an http.Handler which can serve image and audio
-// representations of captchas automatically from the URL. It can also be used
-// to reload captchas.  Refer to Server function documentation for details, or
-// take a look at the example in "capexample" subdirectory.
+// NewServer serves a Generator over gRPC, as defined in the api package, and
+// NewHTTPHandler serves it over HTTP with JSON requests, along with a widget
+// script that adds a captcha to HTML forms. Backends check the solutions
+// submitted in those forms with RequireSolution. Both servers take the same
+// options: rate limits, tenants with their own API keys and settings, and
+// client certificates for privileged methods; the gRPC server can also serve
+// the CaptchaAdmin service. Service runs the servers and shuts them down
+// gracefully.
 package captcha
 
 import (
-	"bytes"
 	"context"
+	"crypto/cipher"
+	"crypto/subtle"
 	"errors"
+	"github.com/roachapp/captcha/pkg/audit"
+	"github.com/roachapp/captcha/pkg/metrics"
 	"github.com/roachapp/captcha/pkg/store"
+	"github.com/roachapp/captcha/pkg/tracing"
 	"github.com/roachapp/captcha/pkg/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"image/color"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -59,61 +49,225 @@ var (
 	ErrNotFound = errors.New("captcha: id not found")
 )
 
+// DefaultTTL is the lifetime of challenges when Generator.TTL isn't set.
+const DefaultTTL = 30 * time.Second
+
 type Generator struct {
 	DigitLen int // default 3
 	Width int // default 160
 	Height int // default 80
+	// Color, if set, is the color of the digits in the images, instead of a
+	// random dark one; see util.NewImageColor.
+	Color color.Color
+	PoWDifficulty int // default 16, in bits
+	TTL time.Duration // default DefaultTTL
+	// PoWTTL, if set, replaces TTL for proof-of-work challenges, and
+	// AnonymousTTL, if set, caps the TTL of challenges issued without a user
+	// id.
+	PoWTTL time.Duration
+	AnonymousTTL time.Duration
+	// Secret is the server secret used to hash and seal solutions before they
+	// are stored. If empty, a random secret is generated on first use.
+	Secret []byte
 	CacheStore store.Store
-	PgStore store.Store
+	PgStore store.Store // optional persistent tier
+	// PersistentTier names PgStore in spans and CaptchaAdmin responses.
+	// Defaults to "postgres".
+	PersistentTier string
+	// Pool, if set, pre-renders the captchas handed out by Issue.
+	Pool *Pool
+	// Audit, if set, records the lifecycle of every challenge.
+	Audit audit.Sink
+	// Tenant is the name of the tenant served by the generator, if any; see
+	// ForTenant.
+	Tenant string
+
+	keysOnce sync.Once
+	hashKey  []byte
+	sealer   cipher.AEAD
+
+	powOnce sync.Once
+	pow     *powPolicy
+
+	users userIndex
 }
 
 // New creates a new captcha with the standard length, saves it in the internal
 // storage and returns its id.
-func (g *Generator) New() string {
-	return g.NewLen(g.DigitLen)
+func (g *Generator) New(ctx context.Context) string {
+	return g.NewLen(ctx, g.DigitLen)
 }
 
 // NewLen is just like New, but accepts length of a captcha solution as the
 // argument.
-func (g *Generator) NewLen(length int) string {
+func (g *Generator) NewLen(ctx context.Context, length int) string {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.NewLen")
+	defer span.End()
+
 	id := util.RandomId()
-	digits := util.RandomDigits(length)
-	g.CacheStore.Set(id, digits)
-	g.PgStore.Set(id, digits)
+	g.set(ctx, id, g.seal(id, util.RandomDigits(length), util.CurrentRNGKeyID()), g.Expiry(imageType, false))
+	metrics.ChallengesIssued.WithLabelValues(imageType).Inc()
 	return id
 }
 
+// Expiry returns the expiration time of a challenge of the given type
+// ("image" or "proof_of_work") issued now, to an anonymous user or not.
+func (g *Generator) Expiry(typ string, anonymous bool) time.Time {
+	ttl := g.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if typ == powType && g.PoWTTL > 0 {
+		ttl = g.PoWTTL
+	}
+	if anonymous && g.AnonymousTTL > 0 && g.AnonymousTTL < ttl {
+		ttl = g.AnonymousTTL
+	}
+	return time.Now().Add(ttl)
+}
+
 // Reload generates and remembers new digits for the given captcha id.  This
 // function returns false if there is no captcha with the given id.
 //
-// After calling this function, the image or audio presented to a user must be
-// refreshed to show the new captcha representation (WriteImage and WriteAudio
-// will write the new one).
-func (g *Generator) Reload(id string) bool {
+// After calling this function, the image presented to a user must be
+// refreshed to show the new captcha (WriteImage will write the new one). The captcha keeps its expiration time, so that it
+// can't be kept alive by reloading it.
+func (g *Generator) Reload(ctx context.Context, id string) bool {
+	rec, expiresAt := g.get(ctx, id, false)
 	var old []byte
-	if old = g.CacheStore.Get(id, false); old == nil {
-		if old = g.PgStore.Get(id, false); old == nil {
-			return false
-		}
+	if rec != nil {
+		old, _ = g.open(id, rec)
+	}
+	if old == nil {
+		g.audit(ctx, audit.Event{Kind: audit.KindReload, ChallengeID: id, Outcome: metrics.OutcomeNotFound})
+		return false
 	}
 
-	digits := util.RandomDigits(len(old))
-	g.CacheStore.Set(id, digits)
-	g.PgStore.Set(id, digits)
+	g.set(ctx, id, g.seal(id, util.RandomDigits(len(old)), util.CurrentRNGKeyID()), expiresAt)
+	metrics.Reloads.Inc()
+	g.audit(ctx, audit.Event{Kind: audit.KindReload, ChallengeID: id, Outcome: metrics.OutcomeOK})
 	return true
 }
 
 // WriteImage writes PNG-encoded image representation of the captcha with the
-// given id. The image will have the given width and height.
-func (g *Generator) WriteImage(w io.Writer, id string, width, height int) error {
-	var d []byte
-	if d = g.CacheStore.Get(id, false); d == nil {
-		if d = g.PgStore.Get(id, false); d == nil {
-			return ErrNotFound
-		}
+// given id. The image will have the given width and height. It returns
+// util.ErrUnknownRNGKey, writing nothing, if the captcha was issued with an
+// RNG key that isn't configured anymore.
+func (g *Generator) WriteImage(ctx context.Context, w io.Writer, id string, width, height int) error {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.WriteImage")
+	defer span.End()
+
+	d, keyID := g.unseal(ctx, id)
+	if d == nil {
+		span.SetStatus(codes.Error, ErrNotFound.Error())
+		g.audit(ctx, audit.Event{Kind: audit.KindRender, ChallengeID: id, Outcome: metrics.OutcomeNotFound})
+		return ErrNotFound
 	}
+	g.audit(ctx, audit.Event{Kind: audit.KindRender, ChallengeID: id, Outcome: metrics.OutcomeOK})
+	return renderImage(ctx, w, keyID, id, d, width, height, g.Color)
+}
 
-	_, err := util.NewImage(id, d, width, height).WriteTo(w)
+// renderImage writes the PNG-encoded image of a captcha and records its render
+// time and size. Drawing and encoding get their own spans.
+func renderImage(ctx context.Context, w io.Writer, keyID, id string, digits []byte, width, height int, c color.Color) error {
+	_, span := tracing.Tracer().Start(ctx, "util.NewImage")
+	start := time.Now()
+	m, err := util.NewImageColor(keyID, id, digits, width, height, c)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return err
+	}
+	metrics.RenderSeconds.Observe(time.Since(start).Seconds())
+	span.End()
+
+	_, span = tracing.Tracer().Start(ctx, "png.Encode")
+	defer span.End()
+	n, err := m.WriteTo(w)
+	m.Release()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	metrics.PNGBytes.Observe(float64(n))
+	span.SetAttributes(attribute.Int64("png.bytes", n))
+	return nil
+}
+
+// unseal returns the solution of the captcha with the given id and the id of
+// the RNG key it is rendered with. Digits are nil if there is no such captcha.
+func (g *Generator) unseal(ctx context.Context, id string) (digits []byte, keyID string) {
+	rec, _ := g.get(ctx, id, false)
+	if rec == nil {
+		return nil, ""
+	}
+	return g.open(id, rec)
+}
+
+// digits is like unseal, but only returns the digits.
+func (g *Generator) digits(ctx context.Context, id string) []byte {
+	d, _ := g.unseal(ctx, id)
+	return d
+}
+
+// set saves the record for the given id in every tier, until expiresAt.
+func (g *Generator) set(ctx context.Context, id string, rec []byte, expiresAt time.Time) {
+	key := g.storeKey(id)
+	storeSet(ctx, cacheTier, g.CacheStore, key, rec, expiresAt)
+	if g.PgStore != nil {
+		storeSet(ctx, g.persistentTier(), g.PgStore, key, rec, expiresAt)
+	}
+}
+
+// get returns the record for the given id from the first tier that has it,
+// and its expiration time. If no tier has it, the record is nil, and the
+// expiration time is in the past if a tier still held it expired, or zero.
+// If clear is set, the record is removed from every tier, which are all
+// queried whether or not the first one had it.
+func (g *Generator) get(ctx context.Context, id string, clear bool) ([]byte, time.Time) {
+	key := g.storeKey(id)
+	rec, expiresAt := storeGet(ctx, cacheTier, g.CacheStore, key, clear)
+	if g.PgStore == nil || (rec != nil && !clear) {
+		return rec, expiresAt
+	}
+	pgRec, pgExpiresAt := storeGet(ctx, g.persistentTier(), g.PgStore, key, clear)
+	if rec == nil && (pgRec != nil || expiresAt.IsZero()) {
+		rec, expiresAt = pgRec, pgExpiresAt
+	}
+	return rec, expiresAt
+}
+
+// Ping returns an error if one of the stores can't be reached.
+func (g *Generator) Ping(ctx context.Context) error {
+	if err := store.Ping(ctx, g.CacheStore); err != nil {
+		return err
+	}
+	if g.PgStore != nil {
+		return store.Ping(ctx, g.PgStore)
+	}
+	return nil
+}
+
+// Close waits for the workers of the Pool, which must have been stopped by
+// cancelling their context, then flushes and closes the stores and the Audit
+// sink. The generator must not be used afterwards.
+func (g *Generator) Close() error {
+	if g.Pool != nil {
+		g.Pool.Wait()
+	}
+	err := store.Close(g.CacheStore)
+	if g.PgStore != nil {
+		if pgErr := store.Close(g.PgStore); err == nil {
+			err = pgErr
+		}
+	}
+	if g.Audit != nil {
+		if auditErr := g.Audit.Close(); err == nil {
+			err = auditErr
+		}
+	}
 	return err
 }
 
@@ -122,53 +276,55 @@ func (g *Generator) WriteImage(w io.Writer, id string, width, height int) error
 //
 // The function deletes the captcha with the given id from the internal
 // storage, so that the same captcha can't be verified anymore.
-func (g *Generator) Verify(id string, digits []byte) bool {
-	if digits == nil || len(digits) == 0 {
-		return false
-	}
-
-	var realDigits []byte
-	cacheDigits := g.CacheStore.Get(id, true)
-	pgDigits := g.PgStore.Get(id, true)
+//
+// Verify does the same store lookups and hashing whether or not the id exists
+// and whatever digits are given, so its timing doesn't tell a caller which
+// ids are valid or how close an answer was.
+func (g *Generator) Verify(ctx context.Context, id string, digits []byte) bool {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.Verify")
+	defer span.End()
 
-	if realDigits = cacheDigits; cacheDigits == nil {
-		if realDigits = pgDigits; pgDigits == nil {
-			return false
-		}
+	rec, expiresAt := g.get(ctx, id, true)
+	found := 1
+	if rec == nil {
+		rec, found = dummyRecord, 0
 	}
+	given := 1 - subtle.ConstantTimeEq(int32(len(digits)), 0)
 
-	return bytes.Equal(digits, realDigits)
+	ok := found&given&g.matches(id, rec, digits) == 1
+	g.validated(ctx, id, imageType, ok, found == 1, found == 0 && !expiresAt.IsZero())
+	return ok
 }
 
 // VerifyString is like Verify, but accepts a string of digits.  It removes
 // spaces and commas from the string, but any other characters, apart from
-// digits and listed above, will cause the function to return false.
-func (g *Generator) VerifyString(id string, digits string) bool {
-	if digits == "" {
-		return false
-	}
-	ns := make([]byte, len(digits))
-	for i := range ns {
+// digits and listed above, will cause the function to return false. The
+// captcha is used up either way.
+func (g *Generator) VerifyString(ctx context.Context, id string, digits string) bool {
+	ns := make([]byte, 0, len(digits))
+	for i := 0; i < len(digits); i++ {
 		d := digits[i]
 		switch {
 		case '0' <= d && d <= '9':
-			ns[i] = d - '0'
+			ns = append(ns, d-'0')
 		case d == ' ' || d == ',':
 			// ignore
 		default:
-			return false
+			return g.Verify(ctx, id, nil)
 		}
 	}
-	return g.Verify(id, ns)
+	return g.Verify(ctx, id, ns)
 }
 
-// DefaultGenerator is used strictly for testing
+// DefaultGenerator is used strictly for testing. Both tiers are memory stores,
+// so that the tests don't depend on a running database.
 func DefaultGenerator() *Generator {
 	return &Generator{
 		CacheStore: store.NewCacheStore(100, 30 * time.Second),
-		PgStore: store.NewPostgresStore(context.Background()),
+		PgStore: store.NewCacheStore(100, 30 * time.Second),
 		DigitLen: 3,
 		Width: 160,
 		Height: 80,
+		PoWDifficulty: 8,
 	}
 }
\ No newline at end of file
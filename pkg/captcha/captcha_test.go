@@ -6,41 +6,124 @@ package captcha
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"github.com/roachapp/captcha/pkg/store"
 	"github.com/roachapp/captcha/pkg/util"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
-	c := DefaultGenerator().New()
+	c := DefaultGenerator().New(context.Background())
 	if c == "" {
 		t.Errorf("expected id, got empty string")
 	}
 }
 
 func TestVerify(t *testing.T) {
+	ctx := context.Background()
 	g := DefaultGenerator()
-	id := g.New()
-	if g.Verify(id, []byte{0, 0}) {
+	id := g.New(ctx)
+	if g.Verify(ctx, id, []byte{0, 0}) {
 		t.Errorf("verified wrong captcha")
 	}
-	id = g.New()
-	d := g.CacheStore.Get(id, false) // cheating
-	if !g.Verify(id, d) {
+	id = g.New(ctx)
+	d := g.digits(ctx, id) // cheating
+	if !g.Verify(ctx, id, d) {
 		t.Errorf("proper captcha not verified")
 	}
 }
 
+func TestVerifyString(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	id := g.NewLen(ctx, 3)
+	d := g.digits(ctx, id) // cheating
+	s := fmt.Sprintf("%d, %d %d", d[0], d[1], d[2])
+	if !g.VerifyString(ctx, id, s) {
+		t.Errorf("proper captcha not verified from %q", s)
+	}
+}
+
+func TestSolutionHashed(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	id := g.NewLen(ctx, 10)
+	d := g.digits(ctx, id) // cheating
+	for _, s := range []store.Store{g.CacheStore, g.PgStore} {
+		rec, _ := s.Get(ctx, id, false)
+		if bytes.Contains(rec, d) {
+			t.Errorf("plain solution %v found in stored record %x", d, rec)
+		}
+	}
+
+	other := DefaultGenerator()
+	other.Secret = []byte("another secret")
+	other.CacheStore, other.PgStore = g.CacheStore, g.PgStore
+	if other.digits(ctx, id) != nil {
+		t.Errorf("record opened with the wrong secret")
+	}
+	if other.Verify(ctx, id, d) {
+		t.Errorf("record verified with the wrong secret")
+	}
+}
+
 func TestReload(t *testing.T) {
+	ctx := context.Background()
 	g := DefaultGenerator()
-	id := g.New()
-	d1 := g.CacheStore.Get(id, false) // cheating
-	g.Reload(id)
-	d2 := g.CacheStore.Get(id, false) // cheating again
+	id := g.New(ctx)
+	d1 := g.digits(ctx, id) // cheating
+	g.Reload(ctx, id)
+	d2 := g.digits(ctx, id) // cheating again
 	if bytes.Equal(d1, d2) {
 		t.Errorf("reload didn't work: %v = %v", d1, d2)
 	}
 }
 
+func TestExpiry(t *testing.T) {
+	g := DefaultGenerator()
+	g.TTL, g.PoWTTL, g.AnonymousTTL = time.Minute, time.Hour, 10*time.Second
+	for _, test := range []struct {
+		typ       string
+		anonymous bool
+		want      time.Duration
+	}{
+		{imageType, false, time.Minute},
+		{imageType, true, 10 * time.Second},
+		{powType, false, time.Hour},
+		{powType, true, 10 * time.Second},
+	} {
+		if ttl := time.Until(g.Expiry(test.typ, test.anonymous)); ttl > test.want || ttl < test.want-time.Second {
+			t.Errorf("Expiry(%s, %v): got a TTL of %v, expected %v", test.typ, test.anonymous, ttl, test.want)
+		}
+	}
+
+	ctx := context.Background()
+	past := time.Now().Add(-time.Second)
+	id, _ := g.NewPoW(ctx, g.PoWDifficulty, past)
+	if rec, expiresAt := g.get(ctx, id, false); rec != nil || !expiresAt.Equal(past) {
+		t.Errorf("expired challenge: got %x, %v", rec, expiresAt)
+	}
+	later := time.Now().Add(time.Hour)
+	id, _, err := g.Issue(ctx, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Reload(ctx, id) {
+		t.Fatal("reload failed")
+	}
+	if _, expiresAt := g.get(ctx, id, false); !expiresAt.Equal(later) {
+		t.Errorf("reload changed the expiration to %v", expiresAt)
+	}
+}
+
 func TestRandomDigits(t *testing.T) {
 	d1 := util.RandomDigits(10)
 	for _, v := range d1 {
@@ -53,3 +136,173 @@ func TestRandomDigits(t *testing.T) {
 		t.Errorf("digits seem to be not random")
 	}
 }
+
+// solvePoW brute-forces a nonce for the given prefix and difficulty.
+func solvePoW(prefix []byte, difficulty int) []byte {
+	for i := 0; ; i++ {
+		nonce := []byte(strconv.Itoa(i))
+		sum := sha256.Sum256(append(append([]byte{}, prefix...), nonce...))
+		if leadingZeroBits(sum[:]) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestVerifyPoW(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	id, prefix := g.NewPoW(ctx, g.PoWDifficulty, g.Expiry(powType, false))
+	if len(prefix) != powPrefixLen {
+		t.Fatalf("expected %d byte prefix, got %d", powPrefixLen, len(prefix))
+	}
+	nonce := solvePoW(prefix, g.PoWDifficulty)
+	if g.Verify(ctx, id, nonce) {
+		t.Errorf("proof-of-work challenge verified as image captcha")
+	}
+
+	id, prefix = g.NewPoW(ctx, g.PoWDifficulty, g.Expiry(powType, false))
+	nonce = solvePoW(prefix, g.PoWDifficulty)
+	if !g.VerifyPoW(ctx, id, nonce) {
+		t.Errorf("proper nonce not verified")
+	}
+	if g.VerifyPoW(ctx, id, nonce) {
+		t.Errorf("proof-of-work challenge verified twice")
+	}
+
+	// An empty nonce consumes the challenge like a wrong one.
+	id, prefix = g.NewPoW(ctx, g.PoWDifficulty, g.Expiry(powType, false))
+	if g.VerifyPoW(ctx, id, nil) {
+		t.Errorf("empty nonce verified")
+	}
+	if g.VerifyPoW(ctx, id, solvePoW(prefix, g.PoWDifficulty)) {
+		t.Errorf("challenge not consumed by an empty nonce")
+	}
+
+	id = g.New(ctx)
+	if g.VerifyPoW(ctx, id, []byte("0")) {
+		t.Errorf("image captcha verified as proof-of-work challenge")
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	for _, v := range []struct {
+		b []byte
+		n int
+	}{
+		{[]byte{0xff}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x00}, 16},
+		{[]byte{0x00, 0x10, 0x00}, 11},
+	} {
+		if n := leadingZeroBits(v.b); n != v.n {
+			t.Errorf("leadingZeroBits(%x) = %d, expected %d", v.b, n, v.n)
+		}
+	}
+}
+
+func TestPoWPolicy(t *testing.T) {
+	p := newPoWPolicy(10)
+	if d := p.difficulty("a"); d != 10 {
+		t.Errorf("expected base difficulty 10, got %d", d)
+	}
+	p.failed("a")
+	if d := p.difficulty("a"); d != 10+powFailureBits {
+		t.Errorf("failure didn't raise difficulty: %d", d)
+	}
+	p.solved("a")
+	for i := 0; i < p.burst; i++ {
+		p.difficulty("b")
+	}
+	if d := p.difficulty("b"); d != 10+powRateBits {
+		t.Errorf("going over the rate didn't raise difficulty: %d", d)
+	}
+	for i := 0; i < MaxPoWDifficulty; i++ {
+		p.failed("c")
+	}
+	if d := p.difficulty("c"); d != MaxPoWDifficulty {
+		t.Errorf("difficulty not capped: %d", d)
+	}
+}
+
+func TestWriteImageAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	defer util.SetRNGKeys(util.RNGKey{ID: util.CurrentRNGKeyID()})
+
+	g := DefaultGenerator()
+	util.SetRNGKeys(util.RNGKey{ID: "old"})
+	id := g.New(ctx)
+	var before, after bytes.Buffer
+	if err := g.WriteImage(ctx, &before, id, g.Width, g.Height); err != nil {
+		t.Fatal(err)
+	}
+	util.SetRNGKeys(util.RNGKey{ID: "new", Key: [32]byte{1}}, util.RNGKey{ID: "old"})
+	if err := g.WriteImage(ctx, &after, id, g.Width, g.Height); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("image changed after key rotation")
+	}
+
+	// Dropped keys fail the render rather than showing another image.
+	util.SetRNGKeys(util.RNGKey{ID: "new", Key: [32]byte{1}})
+	var dropped bytes.Buffer
+	if err := g.WriteImage(ctx, &dropped, id, g.Width, g.Height); err != util.ErrUnknownRNGKey || dropped.Len() != 0 {
+		t.Errorf("dropped key: wrote %d bytes, %v", dropped.Len(), err)
+	}
+	h := NewHTTPHandler(ctx, g)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HTTPPrefix+id+".png", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("dropped key over HTTP: expected 500, got %d", w.Code)
+	}
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	g.Tenant = "pool test" // labels the pool metrics
+	g.Pool = NewPool(g, 4, 2)
+
+	// Empty pool: rendered inline.
+	id, img, err := g.Issue(ctx, g.Expiry(imageType, false))
+	if err != nil || id == "" || len(img) == 0 {
+		t.Fatalf("inline issue failed: %q %d %v", id, len(img), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g.Pool.Start(ctx)
+	for deadline := time.Now().Add(5 * time.Second); g.Pool.Stats().Depth < 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not filled: %+v", g.Pool.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	id, img, err = g.Issue(ctx, g.Expiry(imageType, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered bytes.Buffer
+	if err := g.WriteImage(ctx, &rendered, id, g.Width, g.Height); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img, rendered.Bytes()) {
+		t.Errorf("pre-rendered image differs from WriteImage")
+	}
+	if !g.Verify(ctx, id, g.digits(ctx, id)) {
+		t.Errorf("captcha from the pool not verified")
+	}
+	if s := g.Pool.Stats(); s.Hits != 1 || s.Misses != 1 || s.HitRate() != 0.5 {
+		t.Errorf("wrong pool stats: %+v", s)
+	}
+	for _, result := range []string{metrics.PoolHit, metrics.PoolMiss} {
+		if n := testutil.ToFloat64(metrics.PoolRequests.WithLabelValues(g.Tenant, result)); n != 1 {
+			t.Errorf("got %v pool %ss, expected 1", n, result)
+		}
+	}
+	// The workers may have refilled the pool since.
+	if d := testutil.ToFloat64(metrics.PoolDepth.WithLabelValues(g.Tenant)); d < 3 || d > 4 {
+		t.Errorf("got pool depth %v", d)
+	}
+}
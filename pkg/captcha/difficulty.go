@@ -0,0 +1,167 @@
+package captcha
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/peer"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of tracked clients that triggers removal of idle ones.
+	powCollectNum = 10000
+	// Clients not seen for this long are forgotten.
+	powClientTTL = 10 * time.Minute
+	// Every failed validation adds this many bits of difficulty.
+	powFailureBits = 2
+	// Going over the per-client rate adds this many bits of difficulty.
+	powRateBits = 4
+)
+
+// powClient holds the signals collected for a single client.
+type powClient struct {
+	limiter  *rate.Limiter
+	failures int
+	seen     time.Time
+}
+
+// powOwner is the client a proof-of-work challenge was issued to.
+type powOwner struct {
+	key       string
+	expiresAt time.Time
+}
+
+// powPolicy adjusts the proof-of-work difficulty per client. Each client gets
+// its own token bucket; clients that request challenges faster than the
+// bucket allows, or that keep failing validation, get harder challenges.
+// Validations are usually made by backends rather than by the clients, so the
+// client of every challenge is kept until it's validated or expires.
+type powPolicy struct {
+	sync.Mutex
+	base    int
+	every   time.Duration
+	burst   int
+	clients map[string]*powClient
+	owners  map[string]powOwner // by challenge id
+}
+
+func newPoWPolicy(base int) *powPolicy {
+	if base <= 0 {
+		base = DefaultPoWDifficulty
+	}
+	return &powPolicy{
+		base:    base,
+		every:   time.Second,
+		burst:   5,
+		clients: make(map[string]*powClient),
+		owners:  make(map[string]powOwner),
+	}
+}
+
+// powPolicy returns the proof-of-work difficulty policy of the generator,
+// shared by all servers using it.
+func (g *Generator) powPolicy() *powPolicy {
+	g.powOnce.Do(func() {
+		g.pow = newPoWPolicy(g.PoWDifficulty)
+	})
+	return g.pow
+}
+
+// client returns the entry for key, creating it if needed. Must be called
+// with the lock held.
+func (p *powPolicy) client(key string, now time.Time) *powClient {
+	c, ok := p.clients[key]
+	if !ok {
+		if len(p.clients) >= powCollectNum {
+			p.collect(now)
+		}
+		c = &powClient{limiter: rate.NewLimiter(rate.Every(p.every), p.burst)}
+		p.clients[key] = c
+	}
+	c.seen = now
+	return c
+}
+
+// difficulty returns the difficulty for the next challenge issued to the
+// client with the given key.
+func (p *powPolicy) difficulty(key string) int {
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	c := p.client(key, now)
+	d := p.base + c.failures*powFailureBits
+	if !c.limiter.AllowN(now, 1) {
+		d += powRateBits
+	}
+	if d > MaxPoWDifficulty {
+		d = MaxPoWDifficulty
+	}
+	return d
+}
+
+// issued records that the challenge with the given id was issued to the
+// client with the given key.
+func (p *powPolicy) issued(id, key string, expiresAt time.Time) {
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	if len(p.owners) >= powCollectNum {
+		p.collect(now)
+	}
+	p.owners[id] = powOwner{key, expiresAt}
+}
+
+// owner returns and forgets the key of the client the challenge with the
+// given id was issued to, and false if it's unknown or expired.
+func (p *powPolicy) owner(id string) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	o, ok := p.owners[id]
+	delete(p.owners, id)
+	return o.key, ok && time.Now().Before(o.expiresAt)
+}
+
+// failed records a failed validation for the client with the given key.
+func (p *powPolicy) failed(key string) {
+	p.Lock()
+	defer p.Unlock()
+	p.client(key, time.Now()).failures++
+}
+
+// solved records a successful validation for the client with the given key.
+func (p *powPolicy) solved(key string) {
+	p.Lock()
+	defer p.Unlock()
+	if c := p.client(key, time.Now()); c.failures > 0 {
+		c.failures--
+	}
+}
+
+// collect forgets clients that have been idle for longer than powClientTTL,
+// and the owners of expired challenges. Must be called with the lock held.
+func (p *powPolicy) collect(now time.Time) {
+	for key, c := range p.clients {
+		if now.Sub(c.seen) > powClientTTL {
+			delete(p.clients, key)
+		}
+	}
+	for id, o := range p.owners {
+		if !now.Before(o.expiresAt) {
+			delete(p.owners, id)
+		}
+	}
+}
+
+// clientKey identifies the caller for the proof-of-work policy: the peer's IP
+// address if known, otherwise the fallback (usually the user id).
+func clientKey(ctx context.Context, fallback string) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return fallback
+}
@@ -0,0 +1,70 @@
+package captcha
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+const (
+	// DefaultHealthInterval is how often a Service checks its stores by
+	// default.
+	DefaultHealthInterval = 5 * time.Second
+	// healthTimeout bounds a single check of the stores.
+	healthTimeout = 2 * time.Second
+)
+
+// WithHealth registers the given server as the grpc.health.v1 service of the
+// server created by NewServer, so that its status can be driven from outside,
+// for example by Service. Without it, NewServer registers a health server that
+// always reports SERVING.
+func WithHealth(h *health.Server) ServerOption {
+	return func(o *serverOptions) {
+		o.health = h
+	}
+}
+
+// WithReflection registers the gRPC reflection service in the server created
+// by NewServer, so that tools like grpcurl can list and call its methods.
+func WithReflection() ServerOption {
+	return func(o *serverOptions) {
+		o.reflection = true
+	}
+}
+
+// checkHealth sets the status of the overall server and of the captcha
+// service from the reachability of the generator's stores.
+func (s *Service) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	st := healthpb.HealthCheckResponse_SERVING
+	if err := s.Generator.Ping(ctx); err != nil {
+		log.Warnf("store unreachable, reporting not serving: %v", err)
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	s.Health.SetServingStatus("", st)
+	s.Health.SetServingStatus(pb.Captcha_ServiceDesc.ServiceName, st)
+}
+
+// watchHealth checks the stores every HealthInterval until ctx is cancelled.
+func (s *Service) watchHealth(ctx context.Context) {
+	interval := s.HealthInterval
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.checkHealth(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
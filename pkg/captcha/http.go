@@ -0,0 +1,337 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/roachapp/captcha/pkg/audit"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"github.com/roachapp/captcha/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// HTTPPrefix is the path under which NewHTTPHandler serves captchas.
+const HTTPPrefix = "/captcha/"
+
+// httpChallenge is the JSON representation of a challenge.
+type httpChallenge struct {
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	Width      int32     `json:"width,omitempty"`
+	Height     int32     `json:"height,omitempty"`
+	Image      string    `json:"image,omitempty"`
+	Prefix     []byte    `json:"prefix,omitempty"`
+	Difficulty int32     `json:"difficulty,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// httpUser is the JSON request to create a challenge.
+type httpUser struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// httpSolution is the JSON request to validate a challenge.
+type httpSolution struct {
+	Id   string `json:"id"`
+	Code string `json:"code"`
+	Type string `json:"type"`
+}
+
+// httpStatus is the JSON response to a validation.
+type httpStatus struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+type httpServer struct {
+	srv captchaServer
+	// validateAuth requires a verified client certificate to validate.
+	validateAuth bool
+	tenants      *Tenants
+}
+
+// NewHTTPHandler returns a handler that serves captchas from the generator
+// over HTTP, with the same semantics and rate limits as the gRPC server (pass
+// the same Limiter to both with WithLimiter to share the limit). Creations,
+// reloads and validations are rate limited, image fetches without reload
+// aren't:
+//
+//   POST HTTPPrefix + "new"            creates a challenge; the optional JSON
+//                                      body {"id": ..., "type": ...} selects
+//                                      "image" or "proof_of_work"
+//   GET  HTTPPrefix + "<id>.png"       serves the image of a captcha
+//   GET  HTTPPrefix + "download/<id>.png"
+//                                      serves it as a downloadable file
+//   POST HTTPPrefix + "reload"         reloads the captcha {"id": ...}
+//   POST HTTPPrefix + "validate"       validates {"id", "code", "type"}
+//   GET  HTTPPrefix + "widget.js"      serves the embeddable widget script
+//
+// Appending "?reload=x" to an image URL reloads the captcha before serving
+// it, where x may be anything (for example, current time or a random number
+// to make browsers refetch an image instead of loading it from cache).
+//
+// Audio captchas are not served: this fork doesn't ship the voice samples
+// they are rendered from, so "<id>.wav" is answered with 404 Not Found like
+// any unknown path.
+//
+// With WithTenants, every request but the widget's script must carry an API
+// key, in the X-API-Key header or the api_key query parameter, and requests
+// without a valid one are answered with 401 Unauthorized.
+func NewHTTPHandler(ctx context.Context, capGen *Generator, opts ...ServerOption) http.Handler {
+	o := newServerOptions(opts)
+	return &httpServer{
+		srv: captchaServer{
+			context: ctx,
+			capGen:  capGen,
+			limiter: o.limiter,
+		},
+		validateAuth: o.privileged["/api.Captcha/Validate"],
+		tenants:      o.tenants,
+	}
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, HTTPPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, HTTPPrefix)
+	if name == "widget.js" {
+		serveWidget(w, r)
+		return
+	}
+	ctx := peerContext(r)
+	if h.tenants != nil {
+		var err error
+		if ctx, err = h.tenants.tenantHTTPContext(ctx, r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	// Only the requests that create or check solutions are charged: a user
+	// fetches the image of every challenge, and charging that too would use
+	// up the burst of a single widget.
+	charged := name == "new" || name == "reload" || name == "validate" || r.FormValue("reload") != ""
+	if charged && h.srv.rateLimiter(ctx).Limit() {
+		if name == "validate" {
+			metrics.Validations.WithLabelValues(metrics.OutcomeRateLimited).Inc()
+			h.srv.generator(ctx).audit(ctx, audit.Event{Kind: audit.KindValidate, Outcome: metrics.OutcomeRateLimited})
+		}
+		http.Error(w, "rate limit exceeded, please retry later", http.StatusTooManyRequests)
+		return
+	}
+
+	switch name {
+	case "new":
+		h.create(ctx, w, r)
+	case "reload":
+		h.reload(ctx, w, r)
+	case "validate":
+		h.validate(ctx, w, r)
+	default:
+		dir, file := path.Split(name)
+		if dir != "" && dir != "download/" {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveImage(ctx, w, r, file, dir == "download/")
+	}
+}
+
+func (h *httpServer) create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var u httpUser
+	if !decodeJSON(w, r, &u, true) {
+		return
+	}
+	t, ok := parseChallengeType(u.Type)
+	if !ok {
+		http.Error(w, "unknown challenge type", http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.srv.challenge(ctx, &pb.User{Id: u.Id, Type: t})
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+	resp := httpChallenge{
+		Id:         c.Id,
+		Type:       strings.ToLower(c.Type.String()),
+		Width:      c.Width,
+		Height:     c.Height,
+		Prefix:     c.Prefix,
+		Difficulty: c.Difficulty,
+		ExpiresAt:  c.ExpiresAt.AsTime(),
+	}
+	if c.Type == pb.ChallengeType_IMAGE {
+		resp.Image = HTTPPrefix + c.Id + ".png"
+	}
+	// The widget creates challenges from the pages it's embedded in.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (h *httpServer) reload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var u httpUser
+	if !decodeJSON(w, r, &u, false) {
+		return
+	}
+	if !h.srv.generator(ctx).Reload(ctx, u.Id) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpServer) validate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if h.validateAuth && !verifiedHTTPClient(r) {
+		http.Error(w, errNoClientCert.Error(), http.StatusForbidden)
+		return
+	}
+	var sol httpSolution
+	if !decodeJSON(w, r, &sol, false) {
+		return
+	}
+	t, ok := parseChallengeType(sol.Type)
+	if !ok {
+		http.Error(w, "unknown challenge type", http.StatusBadRequest)
+		return
+	}
+
+	st, err := h.srv.Validate(ctx, &pb.Solution{Id: sol.Id, Code: sol.Code, Type: t})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), httpStatusFromCode(status.Code(err)))
+		return
+	}
+	writeJSON(w, http.StatusOK, httpStatus{Code: st.Code, Message: st.Message})
+}
+
+func (h *httpServer) serveImage(ctx context.Context, w http.ResponseWriter, r *http.Request, file string, download bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	ext := path.Ext(file)
+	id := file[:len(file)-len(ext)]
+	if ext != ".png" || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	g := h.srv.generator(ctx)
+	if r.FormValue("reload") != "" {
+		g.Reload(ctx, id)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Content-Type", "image/png")
+	if download {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	switch err := g.WriteImage(ctx, w, id, g.Width, g.Height); err {
+	case nil:
+	case ErrNotFound:
+		w.Header().Del("Content-Type")
+		http.NotFound(w, r)
+	case util.ErrUnknownRNGKey:
+		// Nothing is written before the key is looked up.
+		log.Errorf("captcha %s: %v", id, err)
+		w.Header().Del("Content-Type")
+		http.Error(w, "failed to render captcha", http.StatusInternalServerError)
+	default:
+		log.Error(err)
+	}
+}
+
+// peerContext returns the request context with the client's address and user
+// agent attached the way gRPC does, so that both servers identify clients the
+// same way.
+func peerContext(r *http.Request) context.Context {
+	ctx := metadata.NewIncomingContext(r.Context(), metadata.Pairs("user-agent", r.UserAgent()))
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return ctx
+	}
+	return peer.NewContext(ctx, &peer.Peer{Addr: addr})
+}
+
+// parseChallengeType parses the name of a challenge type, case-insensitively.
+// The empty string means IMAGE.
+func parseChallengeType(s string) (pb.ChallengeType, bool) {
+	if s == "" {
+		return pb.ChallengeType_IMAGE, true
+	}
+	v, ok := pb.ChallengeType_value[strings.ToUpper(s)]
+	return pb.ChallengeType(v), ok
+}
+
+// decodeJSON decodes the request body into v, answering with 400 Bad Request
+// if it fails. An empty body is accepted if optional is set.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	if r.ContentLength == 0 && optional {
+		return true
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// httpStatusFromCode maps gRPC status codes returned by the captcha server to
+// HTTP status codes.
+func httpStatusFromCode(c codes.Code) int {
+	switch c {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
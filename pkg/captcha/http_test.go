@@ -0,0 +1,244 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// solutionString returns the digits as typed by a user.
+func solutionString(digits []byte) string {
+	b := make([]byte, len(digits))
+	for i, d := range digits {
+		b[i] = '0' + d
+	}
+	return string(b)
+}
+
+func postJSON(t *testing.T, h http.Handler, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPHandler(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	h := NewHTTPHandler(ctx, g, WithLimiter(NewLimiter(time.Hour, 100)))
+
+	w := postJSON(t, h, HTTPPrefix+"new", "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var c httpChallenge
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != "image" || c.Image != HTTPPrefix+c.Id+".png" {
+		t.Errorf("unexpected challenge: %+v", c)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.Image, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("image: %d %s", w.Code, w.Header())
+	}
+	var img bytes.Buffer
+	g.WriteImage(ctx, &img, c.Id, g.Width, g.Height)
+	if !bytes.Equal(w.Body.Bytes(), img.Bytes()) {
+		t.Errorf("served image differs from WriteImage")
+	}
+
+	if w = postJSON(t, h, HTTPPrefix+"reload", `{"id":"`+c.Id+`"}`); w.Code != http.StatusNoContent {
+		t.Errorf("reload: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.Image+"?reload=1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("image reload: %d", w.Code)
+	}
+
+	code := solutionString(g.digits(ctx, c.Id))
+	w = postJSON(t, h, HTTPPrefix+"validate", `{"id":"`+c.Id+`","code":"`+code+`"}`)
+	var st httpStatus
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if w.Code != http.StatusOK || st.Code != 200 {
+		t.Errorf("validate: %d %+v", w.Code, st)
+	}
+	w = postJSON(t, h, HTTPPrefix+"validate", `{"id":"`+c.Id+`","code":"`+code+`"}`)
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if st.Code != 400 {
+		t.Errorf("validated twice: %+v", st)
+	}
+
+	for path, want := range map[string]int{
+		HTTPPrefix + c.Id + ".wav": http.StatusNotFound,
+		HTTPPrefix + "unknown.png": http.StatusNotFound,
+		HTTPPrefix + "a/b/c.png":   http.StatusNotFound,
+		HTTPPrefix + "new":         http.StatusMethodNotAllowed,
+		"/elsewhere":               http.StatusNotFound,
+	} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+
+	w = postJSON(t, h, HTTPPrefix+"new", `{"type":"proof_of_work"}`)
+	c = httpChallenge{}
+	json.Unmarshal(w.Body.Bytes(), &c)
+	if w.Code != http.StatusCreated || c.Type != "proof_of_work" || len(c.Prefix) == 0 || c.Image != "" {
+		t.Errorf("proof-of-work challenge: %d %+v", w.Code, c)
+	}
+	if w = postJSON(t, h, HTTPPrefix+"new", `{"type":"riddle"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown type: expected 400, got %d", w.Code)
+	}
+}
+
+func TestHTTPHandlerSharedLimit(t *testing.T) {
+	l := NewLimiter(time.Hour, 2)
+	g := DefaultGenerator()
+	h := NewHTTPHandler(context.Background(), g, WithLimiter(l))
+	client := dialTestServer(t, captchaServer{context: context.Background(), capGen: g, limiter: l})
+
+	stream, err := client.GetBatch(context.Background(), &pb.BatchRequest{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receiveAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	if w := postJSON(t, h, HTTPPrefix+"new", ""); w.Code != http.StatusCreated {
+		t.Errorf("expected second request to pass, got %d", w.Code)
+	}
+	if w := postJSON(t, h, HTTPPrefix+"new", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected third request to be limited, got %d", w.Code)
+	}
+}
+
+func TestHTTPHandlerWidgetFlow(t *testing.T) {
+	ctx := context.Background()
+	g := DefaultGenerator()
+	h := NewHTTPHandler(ctx, g, WithLimiter(NewLimiter(DefaultRateEvery, DefaultRateBurst)))
+
+	// A user creates a challenge, fetches its image, reloads it once, and
+	// submits the solution, under the default limit.
+	w := postJSON(t, h, HTTPPrefix+"new", "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var c httpChallenge
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{c.Image, c.Image, c.Image + "?reload=1"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d", path, w.Code)
+		}
+	}
+	code := solutionString(g.digits(ctx, c.Id))
+	w = postJSON(t, h, HTTPPrefix+"validate", `{"id":"`+c.Id+`","code":"`+code+`"}`)
+	var st httpStatus
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if w.Code != http.StatusOK || st.Code != 200 {
+		t.Errorf("validate: %d %+v", w.Code, st)
+	}
+	// The burst is used up by the creation, reload and validation.
+	if w = postJSON(t, h, HTTPPrefix+"new", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the next creation to be limited, got %d", w.Code)
+	}
+}
+
+func TestWidget(t *testing.T) {
+	h := NewHTTPHandler(context.Background(), DefaultGenerator(), WithLimiter(NewLimiter(time.Hour, 1)))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HTTPPrefix+"widget.js", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("widget: %d", w.Code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, `"name": "`+FormIdField+`"`) || strings.Contains(body, "{{") {
+			t.Errorf("widget doesn't write the %s field", FormIdField)
+		}
+	}
+}
+
+func TestRequireSolution(t *testing.T) {
+	ctx := context.Background()
+	cs := testServer(100)
+	client := dialTestServer(t, cs)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := RequireSolution(client, next)
+
+	submit := func(id, code string) int {
+		form := url.Values{FormIdField: {id}, FormSolutionField: {code}}
+		r := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := submit("", ""); code != http.StatusForbidden {
+		t.Errorf("missing captcha: expected 403, got %d", code)
+	}
+	id := cs.capGen.New(ctx)
+	if code := submit(id, "x"); code != http.StatusForbidden {
+		t.Errorf("wrong solution: expected 403, got %d", code)
+	}
+	id = cs.capGen.New(ctx)
+	if code := submit(id, solutionString(cs.capGen.digits(ctx, id))); code != http.StatusTeapot {
+		t.Errorf("right solution: expected the form handler to run, got %d", code)
+	}
+}
+
+// failingClient fails every Validate call with err.
+type failingClient struct {
+	pb.CaptchaClient
+	err error
+}
+
+func (c failingClient) Validate(context.Context, *pb.Solution, ...grpc.CallOption) (*pb.Status, error) {
+	return nil, c.err
+}
+
+func TestRequireSolutionErrors(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	for c, want := range map[codes.Code]int{
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.PermissionDenied:  http.StatusInternalServerError,
+		codes.Unauthenticated:   http.StatusInternalServerError,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+	} {
+		h := RequireSolution(failingClient{err: status.Error(c, "failed")}, next)
+		form := url.Values{FormIdField: {"id"}, FormSolutionField: {"123"}}
+		r := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", c, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"github.com/roachapp/captcha/pkg/store"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownTimeout is the default time given to in-flight requests to
+// complete on shutdown.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Service runs the gRPC and, optionally, HTTP servers of a generator and
+// manages their lifecycle:
+//
+//   svc := &captcha.Service{Generator: g, GRPC: captcha.NewServer(ctx, g)}
+//   err := svc.Run(ctx, lis, nil) // until ctx is cancelled, e.g. on SIGTERM
+//
+// If Health is set, it reports SERVING while the generator's stores can be
+// reached, and NOT_SERVING otherwise and from the start of the shutdown. Pass
+// it to NewServer with WithHealth.
+//
+// When the context passed to Run is cancelled, the servers stop accepting
+// requests and in-flight ones are given ShutdownTimeout to complete, after
+// which they are cut off. Then the generator's Pool is stopped and its stores
+// are flushed and closed.
+//
+// The Pools of the generators of Tenants are started and stopped with the
+// Generator's; their stores are the Generator's, see ForTenant.
+//
+// If SnapshotFile is set, the generator's CacheStore is restored from it
+// before the servers start, and saved to it before the stores are closed, so
+// that outstanding challenges survive a restart without a persistent tier.
+type Service struct {
+	Generator *Generator
+	Tenants   *Tenants // optional
+	GRPC      *grpc.Server
+	HTTP      *http.Server   // optional
+	Health    *health.Server // optional
+	// HealthInterval is how often Health is updated. Defaults to
+	// DefaultHealthInterval.
+	HealthInterval time.Duration
+	// ShutdownTimeout bounds the draining of in-flight requests. Defaults to
+	// DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// SnapshotFile holds the challenges of the CacheStore between runs,
+	// encrypted with SnapshotKey. The CacheStore must be a store.Dumper, and
+	// the Generator must have no PgStore: get trusts the CacheStore, so a
+	// restored challenge could be solved again after the persistent tier
+	// consumed it.
+	SnapshotFile string
+	SnapshotKey  []byte
+}
+
+// Run starts the Pools of the generators, if any, serves the gRPC server on grpcLis
+// and the HTTP server on httpLis, if both are set, and shuts everything down
+// when ctx is cancelled or one of the servers fails. It returns the error of
+// the failed server, or nil after a shutdown caused by ctx. It fails before
+// serving if SnapshotFile is set and can't be used.
+func (s *Service) Run(ctx context.Context, grpcLis, httpLis net.Listener) error {
+	if s.SnapshotFile != "" {
+		switch {
+		case !store.CanDump(s.Generator.CacheStore):
+			return fmt.Errorf("captcha: snapshot file %s: %v", s.SnapshotFile, store.ErrNotDumper)
+		case s.Generator.PgStore != nil:
+			return fmt.Errorf("captcha: snapshot file %s: the generator has a persistent store", s.SnapshotFile)
+		}
+		n, err := store.RestoreSnapshot(ctx, s.Generator.CacheStore, s.SnapshotFile, s.SnapshotKey)
+		if rerr, ok := err.(*store.SnapshotRemoveError); ok {
+			// Restoring it would let its challenges be solved again after
+			// the next restart.
+			log.Errorf("failed to remove snapshot %s, its challenges are dropped: %v", rerr.Path, rerr.Err)
+		} else if err != nil {
+			// Starting without the challenges beats not starting.
+			log.Errorf("failed to restore snapshot: %v", err)
+		} else if n > 0 {
+			log.Infof("restored %d challenges from %s", n, s.SnapshotFile)
+		}
+	}
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	for _, p := range s.pools() {
+		p.Start(bgCtx)
+	}
+	if s.Health != nil {
+		s.checkHealth(ctx)
+		go s.watchHealth(bgCtx)
+	}
+
+	failed := make(chan error, 2)
+	go func() {
+		failed <- s.GRPC.Serve(grpcLis)
+	}()
+	if s.HTTP != nil && httpLis != nil {
+		go func() {
+			if err := s.HTTP.Serve(httpLis); err != http.ErrServerClosed {
+				failed <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down")
+	case err = <-failed:
+		log.Errorf("server failed, shutting down: %v", err)
+	}
+
+	if s.Health != nil {
+		// Shutdown reports NOT_SERVING and ignores the updates of watchHealth
+		// until it stops.
+		s.Health.Shutdown()
+	}
+	s.drain()
+	stopBackground()
+	for _, p := range s.pools() {
+		p.Wait()
+	}
+	if s.SnapshotFile != "" {
+		n, serr := store.SaveSnapshot(s.Generator.CacheStore, s.SnapshotFile, s.SnapshotKey)
+		if serr != nil {
+			log.Errorf("failed to save snapshot: %v", serr)
+			if err == nil {
+				err = serr
+			}
+		} else {
+			log.Infof("saved %d challenges to %s", n, s.SnapshotFile)
+		}
+	}
+	if cerr := s.Generator.Close(); cerr != nil {
+		log.Errorf("failed to close stores: %v", cerr)
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// pools returns the Pools of the Generator and of the tenants.
+func (s *Service) pools() []*Pool {
+	var pools []*Pool
+	if s.Generator.Pool != nil {
+		pools = append(pools, s.Generator.Pool)
+	}
+	if s.Tenants != nil {
+		for _, t := range s.Tenants.All() {
+			if t.Generator.Pool != nil {
+				pools = append(pools, t.Generator.Pool)
+			}
+		}
+	}
+	return pools
+}
+
+// drain stops the servers, waiting at most ShutdownTimeout for in-flight
+// requests.
+func (s *Service) drain() {
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		s.GRPC.GracefulStop()
+		close(drained)
+	}()
+	if s.HTTP != nil {
+		if err := s.HTTP.Shutdown(ctx); err != nil {
+			log.Warnf("HTTP server didn't drain in %s: %v", timeout, err)
+			s.HTTP.Close()
+		}
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Warnf("gRPC server didn't drain in %s, cutting off in-flight requests", timeout)
+		s.GRPC.Stop()
+		<-drained
+	}
+}
@@ -0,0 +1,286 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"github.com/roachapp/captcha/pkg/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// closeStore records whether it was closed.
+type closeStore struct {
+	store.Store
+	closed chan struct{}
+}
+
+func (s *closeStore) Close() error {
+	close(s.closed)
+	return nil
+}
+
+// blockingService returns a service whose Validate calls signal started and
+// block until release is closed, and the store closed on shutdown.
+func blockingService(timeout time.Duration) (svc *Service, started, release chan struct{}, st *closeStore) {
+	started, release = make(chan struct{}, 1), make(chan struct{})
+	block := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == "/api.Captcha/Validate" {
+			started <- struct{}{}
+			<-release
+		}
+		return handler(ctx, req)
+	}
+
+	g := DefaultGenerator()
+	st = &closeStore{Store: g.CacheStore, closed: make(chan struct{})}
+	g.CacheStore = st
+	g.Pool = NewPool(g, 2, 1)
+	svc = &Service{
+		Generator:       g,
+		GRPC:            NewServer(context.Background(), g, WithGRPCOptions(grpc.ChainUnaryInterceptor(block))),
+		ShutdownTimeout: timeout,
+	}
+	return svc, started, release, st
+}
+
+// runService runs svc on a loopback listener until the returned cancel
+// function is called, and returns a connected client and Run's result.
+func runService(t *testing.T, svc *Service) (pb.CaptchaClient, context.CancelFunc, chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- svc.Run(ctx, lis, nil) }()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewCaptchaClient(conn), cancel, done
+}
+
+func TestServiceDrains(t *testing.T) {
+	ctx := context.Background()
+	svc, started, release, st := blockingService(time.Minute)
+	client, cancel, done := runService(t, svc)
+	id := svc.Generator.New(ctx)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Validate(context.Background(), &pb.Solution{Id: id, Code: "000"})
+		result <- err
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-result; err != nil {
+		t.Errorf("in-flight Validate failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+	select {
+	case <-st.closed:
+	default:
+		t.Errorf("store not closed")
+	}
+}
+
+func TestServiceShutdownTimeout(t *testing.T) {
+	svc, started, release, st := blockingService(50*time.Millisecond)
+	defer close(release)
+	client, cancel, done := runService(t, svc)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Validate(context.Background(), &pb.Solution{Id: "id", Code: "000"})
+		result <- err
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the shutdown timeout")
+	}
+	if err := <-result; err == nil {
+		t.Errorf("in-flight Validate succeeded after the shutdown timeout")
+	}
+	select {
+	case <-st.closed:
+	default:
+		t.Errorf("store not closed")
+	}
+}
+
+// pingStore is a store whose reachability can be switched.
+type pingStore struct {
+	store.Store
+	down int32
+}
+
+func (s *pingStore) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&s.down) != 0 {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+// healthStatus returns the status of the captcha service reported by h, or
+// SERVICE_UNKNOWN before it's first set.
+func healthStatus(h *health.Server) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "api.Captcha"})
+	if err != nil {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	return resp.Status
+}
+
+// waitHealth waits for h to report want.
+func waitHealth(t *testing.T, h *health.Server, want healthpb.HealthCheckResponse_ServingStatus) {
+	deadline := time.Now().Add(5 * time.Second)
+	for healthStatus(h) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("health: got %v, expected %v", healthStatus(h), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServiceHealth(t *testing.T) {
+	svc, started, release, _ := blockingService(time.Minute)
+	pg := &pingStore{Store: svc.Generator.PgStore}
+	svc.Generator.PgStore = pg
+	svc.Health = health.NewServer()
+	svc.HealthInterval = 5 * time.Millisecond
+	svc.GRPC = NewServer(context.Background(), svc.Generator,
+		WithHealth(svc.Health), WithReflection(),
+		WithGRPCOptions(grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if info.FullMethod == "/api.Captcha/Validate" {
+				started <- struct{}{}
+				<-release
+			}
+			return handler(ctx, req)
+		})),
+	)
+	for _, name := range []string{"grpc.health.v1.Health", "grpc.reflection.v1alpha.ServerReflection"} {
+		if _, ok := svc.GRPC.GetServiceInfo()[name]; !ok {
+			t.Errorf("%s not registered", name)
+		}
+	}
+
+	client, cancel, done := runService(t, svc)
+	waitHealth(t, svc.Health, healthpb.HealthCheckResponse_SERVING)
+	atomic.StoreInt32(&pg.down, 1)
+	waitHealth(t, svc.Health, healthpb.HealthCheckResponse_NOT_SERVING)
+	atomic.StoreInt32(&pg.down, 0)
+	waitHealth(t, svc.Health, healthpb.HealthCheckResponse_SERVING)
+
+	// Draining reports not serving, even though the stores are reachable.
+	go client.Validate(context.Background(), &pb.Solution{Id: "id", Code: "000"})
+	<-started
+	cancel()
+	waitHealth(t, svc.Health, healthpb.HealthCheckResponse_NOT_SERVING)
+	time.Sleep(20 * time.Millisecond)
+	if st := healthStatus(svc.Health); st != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("health while draining: got %v", st)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
+
+func TestServiceSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(tempDir(t), "cache.snap")
+	service := func() *Service {
+		g := DefaultGenerator()
+		g.Secret = []byte("shared secret")
+		g.PgStore = nil
+		return &Service{
+			Generator:    g,
+			GRPC:         NewServer(ctx, g, WithLimiter(NewLimiter(time.Hour, 10))),
+			SnapshotFile: path,
+			SnapshotKey:  []byte("snapshot key"),
+		}
+	}
+
+	svc := service()
+	client, cancel, done := runService(t, svc)
+	c, err := client.Get(ctx, &pb.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sol := &pb.Solution{Id: c.Id, Code: solutionString(svc.Generator.digits(ctx, c.Id))}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	client, cancel, done = runService(t, service())
+	if st, err := client.Validate(ctx, sol); err != nil || st.Code != 200 {
+		t.Errorf("challenge not restored after a restart: %v %v", st, err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestServiceSnapshotUnusable(t *testing.T) {
+	path := filepath.Join(tempDir(t), "cache.snap")
+	tests := []struct {
+		name  string
+		setup func(g *Generator)
+	}{
+		{"not a dumper", func(g *Generator) {
+			g.PgStore = nil
+			g.CacheStore = &closeStore{Store: g.CacheStore, closed: make(chan struct{})}
+		}},
+		{"persistent store", func(g *Generator) {
+			g.PgStore = store.NewCacheStore(10, time.Minute)
+		}},
+	}
+	for _, test := range tests {
+		g := DefaultGenerator()
+		test.setup(g)
+		svc := &Service{
+			Generator:    g,
+			GRPC:         NewServer(context.Background(), g),
+			SnapshotFile: path,
+			SnapshotKey:  []byte("snapshot key"),
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Run must fail before serving, so it doesn't wait for ctx.
+		if err := svc.Run(context.Background(), lis, nil); err == nil {
+			t.Errorf("%s: Run succeeded", test.name)
+		}
+		lis.Close()
+	}
+}
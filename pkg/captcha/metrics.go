@@ -0,0 +1,63 @@
+package captcha
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/audit"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Challenge type names in metrics and audit events.
+const (
+	imageType = "image"
+	powType   = "proof_of_work"
+)
+
+// validated counts a validation of the challenge by its outcome, records it on
+// the span of ctx and audits it. Expired challenges are not found.
+func (g *Generator) validated(ctx context.Context, id, typ string, ok, found, expired bool) {
+	outcome := metrics.OutcomeOK
+	switch {
+	case expired:
+		outcome = metrics.OutcomeExpired
+	case !found:
+		outcome = metrics.OutcomeNotFound
+	case !ok:
+		outcome = metrics.OutcomeWrong
+	}
+	metrics.Validations.WithLabelValues(outcome).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("captcha.outcome", outcome))
+	g.audit(ctx, audit.Event{Kind: audit.KindValidate, ChallengeID: id, Type: typ, Outcome: outcome})
+}
+
+// observeRPC records a finished gRPC request. Validations rejected by the
+// rate limit never reach the Generator, so they are counted here.
+func observeRPC(method string, start time.Time, err error) {
+	code := status.Code(err)
+	metrics.GRPCRequests.WithLabelValues(method, code.String()).Inc()
+	metrics.GRPCSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if method == "/api.Captcha/Validate" && code == codes.ResourceExhausted {
+		metrics.Validations.WithLabelValues(metrics.OutcomeRateLimited).Inc()
+	}
+}
+
+// metricsUnaryInterceptor records the requests of unary RPCs.
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// metricsStreamInterceptor records the requests of streaming RPCs.
+func metricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,91 @@
+package captcha
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// validationCounts returns the current value of the validation counters.
+func validationCounts() map[string]float64 {
+	m := make(map[string]float64)
+	for _, o := range []string{metrics.OutcomeOK, metrics.OutcomeWrong, metrics.OutcomeNotFound, metrics.OutcomeExpired, metrics.OutcomeRateLimited} {
+		m[o] = testutil.ToFloat64(metrics.Validations.WithLabelValues(o))
+	}
+	return m
+}
+
+// histogramCount returns the number of observations of the named histogram.
+func histogramCount(t *testing.T, name string) uint64 {
+	mfs, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	g := DefaultGenerator()
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(context.Background(), g, WithLimiter(NewLimiter(time.Hour, 5)))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewCaptchaClient(conn)
+
+	before := validationCounts()
+	issued := testutil.ToFloat64(metrics.ChallengesIssued.WithLabelValues(imageType))
+	rendered := histogramCount(t, "captcha_render_seconds")
+
+	ctx := context.Background()
+	c, err := client.Get(ctx, &pb.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(metrics.ChallengesIssued.WithLabelValues(imageType)); n != issued+1 {
+		t.Errorf("issued: got %v, expected %v", n, issued+1)
+	}
+	if n := histogramCount(t, "captcha_render_seconds"); n != rendered+1 {
+		t.Errorf("renders: got %d, expected %d", n, rendered+1)
+	}
+
+	g.TTL = time.Millisecond
+	expired := g.New(ctx)
+	g.TTL = 0
+	time.Sleep(2 * time.Millisecond)
+	client.Validate(ctx, &pb.Solution{Id: expired, Code: "000"})
+
+	id := g.New(ctx)
+	client.Validate(ctx, &pb.Solution{Id: id, Code: solutionString(g.digits(ctx, id))})
+	client.Validate(ctx, &pb.Solution{Id: c.Id, Code: "x"})
+	client.Validate(ctx, &pb.Solution{Id: "unknown", Code: "000"})
+	if _, err := client.Validate(ctx, &pb.Solution{Id: "unknown", Code: "000"}); err == nil {
+		t.Fatal("rate limit not hit")
+	}
+
+	after := validationCounts()
+	for outcome := range before {
+		if after[outcome] != before[outcome]+1 {
+			t.Errorf("%s: got %v validations, expected %v", outcome, after[outcome], before[outcome]+1)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package captcha
+
+import (
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// RequireSolution returns middleware for form handlers of backend services. It
+// validates the captcha submitted in the FormIdField and FormSolutionField
+// form fields (as written by the widget) with the captcha service, and only
+// calls next if the solution is right. Requests without a valid solution are
+// answered with 403 Forbidden. If the captcha service rejects the validation,
+// the request is answered with 429 Too Many Requests when the rate limit is
+// exceeded, with 500 Internal Server Error when the backend isn't allowed to
+// validate, which is a misconfiguration, and with 503 Service Unavailable
+// otherwise. Audio captchas aren't supported, so only image and
+// proof-of-work solutions are checked.
+func RequireSolution(client pb.CaptchaClient, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.FormValue(FormIdField)
+		code := r.FormValue(FormSolutionField)
+		if id == "" || code == "" {
+			http.Error(w, "captcha required", http.StatusForbidden)
+			return
+		}
+
+		st, err := client.Validate(r.Context(), &pb.Solution{Id: id, Code: code})
+		if err != nil {
+			validateFailed(w, err)
+			return
+		}
+		if st.Code != http.StatusOK {
+			http.Error(w, "wrong captcha solution", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateFailed answers a request whose solution couldn't be validated
+// because of err.
+func validateFailed(w http.ResponseWriter, err error) {
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		http.Error(w, "too many captcha attempts, please retry later", http.StatusTooManyRequests)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		log.Errorf("captcha: validate: the captcha service rejected this backend's credentials, check its API key or client certificate: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		log.Errorf("captcha: validate: %v", err)
+		http.Error(w, "captcha service unavailable", http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,170 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"github.com/roachapp/captcha/pkg/tracing"
+	"github.com/roachapp/captcha/pkg/util"
+	"go.opentelemetry.io/otel/attribute"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prerendered is an image captcha that is ready to be issued. Its record is
+// only saved in the stores when it's issued, so that it doesn't expire while
+// waiting in the pool.
+type prerendered struct {
+	id    string
+	rec   []byte
+	image []byte
+}
+
+// PoolStats describes the state of a Pool.
+type PoolStats struct {
+	// Number of captchas ready to be issued.
+	Depth int
+	// Maximum number of captchas kept ready.
+	Capacity int
+	// Number of captchas issued from the pool.
+	Hits uint64
+	// Number of captchas that had to be rendered inline because the pool
+	// was empty.
+	Misses uint64
+}
+
+// HitRate returns the fraction of captchas issued from the pool.
+func (s PoolStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Pool pre-renders image captchas in the background with a bounded number of
+// workers, so that issuing a captcha doesn't have to wait for rendering and
+// PNG encoding. Register it as Generator.Pool and start it with Start.
+type Pool struct {
+	g       *Generator
+	workers int
+	ready   chan *prerendered
+	running sync.WaitGroup
+	hits    uint64
+	misses  uint64
+}
+
+// NewPool returns a new pool of at most size captchas of the generator's
+// length and dimensions, rendered by the given number of workers.
+func NewPool(g *Generator, size, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		g:       g,
+		workers: workers,
+		ready:   make(chan *prerendered, size),
+	}
+}
+
+// Start starts the workers, which keep the pool filled until the context is
+// cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	p.running.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.work(ctx)
+	}
+}
+
+// Wait waits for the workers to stop after their context is cancelled.
+func (p *Pool) Wait() {
+	p.running.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.running.Done()
+	for {
+		c, err := p.g.render(ctx)
+		if err != nil {
+			return
+		}
+		// Blocks while the pool is full.
+		select {
+		case p.ready <- c:
+			p.observeDepth()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// get returns a pre-rendered captcha, or nil if the pool is empty.
+func (p *Pool) get() *prerendered {
+	select {
+	case c := <-p.ready:
+		atomic.AddUint64(&p.hits, 1)
+		metrics.PoolRequests.WithLabelValues(p.g.Tenant, metrics.PoolHit).Inc()
+		p.observeDepth()
+		return c
+	default:
+		atomic.AddUint64(&p.misses, 1)
+		metrics.PoolRequests.WithLabelValues(p.g.Tenant, metrics.PoolMiss).Inc()
+		return nil
+	}
+}
+
+// observeDepth sets the depth gauge of the pool's tenant.
+func (p *Pool) observeDepth() {
+	metrics.PoolDepth.WithLabelValues(p.g.Tenant).Set(float64(len(p.ready)))
+}
+
+// Stats returns the current depth and the hit counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Depth:    len(p.ready),
+		Capacity: cap(p.ready),
+		Hits:     atomic.LoadUint64(&p.hits),
+		Misses:   atomic.LoadUint64(&p.misses),
+	}
+}
+
+// render creates a new image captcha with the generator's settings without
+// saving it in the stores.
+func (g *Generator) render(ctx context.Context) (*prerendered, error) {
+	id := util.RandomId()
+	digits := util.RandomDigits(g.DigitLen)
+	keyID := util.CurrentRNGKeyID()
+
+	var buf bytes.Buffer
+	if err := renderImage(ctx, &buf, keyID, id, digits, g.Width, g.Height, g.Color); err != nil {
+		return nil, err
+	}
+	return &prerendered{
+		id:    id,
+		rec:   g.seal(id, digits, keyID),
+		image: buf.Bytes(),
+	}, nil
+}
+
+// Issue creates a new image captcha with the generator's settings, saves it in
+// the internal storage until expiresAt and returns its id and PNG-encoded
+// image. The captcha is taken from the Pool if there is one and it isn't
+// empty, otherwise it's rendered inline.
+func (g *Generator) Issue(ctx context.Context, expiresAt time.Time) (id string, image []byte, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.Issue")
+	defer span.End()
+
+	var c *prerendered
+	if g.Pool != nil {
+		c = g.Pool.get()
+	}
+	if c == nil {
+		span.SetAttributes(attribute.Bool("captcha.pool_miss", true))
+		if c, err = g.render(ctx); err != nil {
+			return "", nil, err
+		}
+	}
+	g.set(ctx, c.id, c.rec, expiresAt)
+	metrics.ChallengesIssued.WithLabelValues(imageType).Inc()
+	return c.id, c.image, nil
+}
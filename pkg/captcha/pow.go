@@ -0,0 +1,86 @@
+package captcha
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/roachapp/captcha/pkg/metrics"
+	"github.com/roachapp/captcha/pkg/tracing"
+	"github.com/roachapp/captcha/pkg/util"
+	"math/bits"
+	"time"
+)
+
+const (
+	// Default number of leading zero bits a proof-of-work solution must have.
+	DefaultPoWDifficulty = 16
+	// Maximum difficulty that can be requested, in bits.
+	MaxPoWDifficulty = 32
+	// Length of the random prefix of a proof-of-work challenge.
+	powPrefixLen = 16
+	// powTag marks proof-of-work records in the store, and imageTag image
+	// captcha records, so a challenge can't be verified as the other kind.
+	powTag = 0xff
+)
+
+// NewPoW creates a new hashcash-style proof-of-work challenge with the given
+// difficulty, saves it in the internal storage until expiresAt and returns its
+// id and the random prefix the client has to work on.
+//
+// A solution is a nonce such that SHA-256(prefix || nonce) starts with at
+// least difficulty zero bits.
+func (g *Generator) NewPoW(ctx context.Context, difficulty int, expiresAt time.Time) (id string, prefix []byte) {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.NewPoW")
+	defer span.End()
+
+	if difficulty < 1 {
+		difficulty = 1
+	}
+	if difficulty > MaxPoWDifficulty {
+		difficulty = MaxPoWDifficulty
+	}
+	id = util.RandomId()
+	prefix = util.RandomBytes(powPrefixLen)
+
+	rec := make([]byte, 0, 2+len(prefix))
+	rec = append(rec, powTag, byte(difficulty))
+	rec = append(rec, prefix...)
+	g.set(ctx, id, rec, expiresAt)
+	metrics.ChallengesIssued.WithLabelValues(powType).Inc()
+	return id, prefix
+}
+
+// VerifyPoW returns true if the given nonce solves the proof-of-work challenge
+// with the given id.
+//
+// Like Verify, the function deletes the challenge from the internal storage,
+// so that the same challenge can't be verified anymore.
+func (g *Generator) VerifyPoW(ctx context.Context, id string, nonce []byte) bool {
+	ctx, span := tracing.Tracer().Start(ctx, "captcha.VerifyPoW")
+	defer span.End()
+
+	rec, expiresAt := g.get(ctx, id, true)
+	if len(rec) < 2 || rec[0] != powTag {
+		g.validated(ctx, id, powType, false, false, rec == nil && !expiresAt.IsZero())
+		return false
+	}
+
+	// An empty nonce is a wrong one, even if the hash of the prefix alone
+	// meets the difficulty.
+	h := sha256.New()
+	h.Write(rec[2:])
+	h.Write(nonce)
+	ok := len(nonce) > 0 && leadingZeroBits(h.Sum(nil)) >= int(rec[1])
+	g.validated(ctx, id, powType, ok, true, false)
+	return ok
+}
+
+// leadingZeroBits returns the number of leading zero bits of b.
+func leadingZeroBits(b []byte) (n int) {
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
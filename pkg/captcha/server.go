@@ -1,26 +1,56 @@
 package captcha
 
 import (
-	"bytes"
 	"context"
 	"github.com/grpc-ecosystem/go-grpc-middleware"
-	"github.com/grpc-ecosystem/go-grpc-middleware/ratelimit"
+	"github.com/roachapp/captcha/pkg/audit"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"time"
 
 	pb "github.com/roachapp/captcha/api"
 )
 
+// MaxBatch is the maximum number of challenges returned by GetBatch.
+const MaxBatch = 100
+
 type captchaServer struct {
 	pb.UnimplementedCaptchaServer
 	context context.Context
 	capGen *Generator
+	limiter *Limiter
 }
 
 func (srv captchaServer) Validate(ctx context.Context, sol *pb.Solution) (*pb.Status, error) {
-	if !srv.capGen.VerifyString(sol.Id, sol.Code) {
+	g := srv.generator(ctx)
+	var ok bool
+	switch sol.Type {
+	case pb.ChallengeType_PROOF_OF_WORK:
+		// The outcome counts for the client the challenge was issued to,
+		// under the key its difficulty is looked up with, rather than for
+		// the caller, which is usually a backend.
+		key, known := g.powPolicy().owner(sol.Id)
+		ok = g.VerifyPoW(ctx, sol.Id, []byte(sol.Code))
+		switch {
+		case !known:
+		case ok:
+			g.powPolicy().solved(key)
+		default:
+			g.powPolicy().failed(key)
+		}
+	default:
+		ok = g.VerifyString(ctx, sol.Id, sol.Code)
+	}
+
+	if !ok {
 		return &pb.Status{
 			Code: 400,
 			Message: "try again :(",
@@ -35,42 +65,250 @@ func (srv captchaServer) Validate(ctx context.Context, sol *pb.Solution) (*pb.St
 }
 
 func (srv captchaServer) Get(ctx context.Context, sol *pb.User) (*pb.Challenge, error) {
-	captchaID := srv.capGen.New()
+	return srv.challenge(ctx, sol)
+}
+
+// GetBatch streams up to MaxBatch challenges. Every challenge counts against
+// the rate limit; the stream ends with ResourceExhausted as soon as the limit
+// is hit, and with the context's error when the client goes away.
+func (srv captchaServer) GetBatch(req *pb.BatchRequest, stream pb.Captcha_GetBatchServer) error {
+	if req.Count <= 0 {
+		return status.Errorf(codes.InvalidArgument, "count must be positive, got %d", req.Count)
+	}
+	n := int(req.Count)
+	if n > MaxBatch {
+		n = MaxBatch
+	}
+	user := req.User
+	if user == nil {
+		user = &pb.User{}
+	}
+
+	ctx := stream.Context()
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		if srv.rateLimiter(ctx).Limit() {
+			return status.Errorf(codes.ResourceExhausted, "batch rejected by rate limit after %d challenges, please retry later", i)
+		}
+		c, err := srv.challenge(ctx, user)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generator returns the generator of the tenant of ctx, or the server's if
+// there is none.
+func (srv captchaServer) generator(ctx context.Context) *Generator {
+	if t := tenantFromContext(ctx); t != nil {
+		return t.Generator
+	}
+	return srv.capGen
+}
+
+// rateLimiter returns the limiter of the tenant of ctx, or the server's if
+// there is none or it has no limiter of its own.
+func (srv captchaServer) rateLimiter(ctx context.Context) *Limiter {
+	if t := tenantFromContext(ctx); t != nil && t.Limiter != nil {
+		return t.Limiter
+	}
+	return srv.limiter
+}
 
-	var content bytes.Buffer
+// challenge issues a challenge of the type requested by the user. Challenges
+// of identified users are tracked for CaptchaAdmin.RevokeUser.
+func (srv captchaServer) challenge(ctx context.Context, sol *pb.User) (*pb.Challenge, error) {
+	g := srv.generator(ctx)
+	if sol.Type == pb.ChallengeType_PROOF_OF_WORK {
+		expiresAt := g.Expiry(powType, sol.Id == "")
+		key := clientKey(ctx, sol.Id)
+		difficulty := g.powPolicy().difficulty(key)
+		captchaID, prefix := g.NewPoW(ctx, difficulty, expiresAt)
+		g.powPolicy().issued(captchaID, key, expiresAt)
+		if sol.Id != "" {
+			g.users.track(sol.Id, captchaID)
+		}
+		g.audit(ctx, audit.Event{
+			Kind:        audit.KindIssue,
+			ChallengeID: captchaID,
+			User:        sol.Id,
+			Type:        powType,
+			Difficulty:  difficulty,
+		})
+		return &pb.Challenge{
+			Id:         captchaID,
+			Type:       pb.ChallengeType_PROOF_OF_WORK,
+			Prefix:     prefix,
+			Difficulty: int32(difficulty),
+			ExpiresAt:  timestamppb.New(expiresAt),
+		}, nil
+	}
 
-	if err := srv.capGen.WriteImage(&content, captchaID, srv.capGen.Width, srv.capGen.Height); err != nil {
+	expiresAt := g.Expiry(imageType, sol.Id == "")
+	captchaID, content, err := g.Issue(ctx, expiresAt)
+	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	if sol.Id != "" {
+		g.users.track(sol.Id, captchaID)
+	}
+	g.audit(ctx, audit.Event{
+		Kind:        audit.KindIssue,
+		ChallengeID: captchaID,
+		User:        sol.Id,
+		Type:        imageType,
+	})
 
 	return &pb.Challenge{
 		Id:         captchaID,
-		Width:      int32(srv.capGen.Width),
-		Height:     int32(srv.capGen.Height),
-		GrayPixels: content.Bytes(),
+		Width:      int32(g.Width),
+		Height:     int32(g.Height),
+		GrayPixels: content,
+		ExpiresAt:  timestamppb.New(expiresAt),
 	}, nil
 }
 
-type rateLimiter struct {
+// Default rate limit of the servers.
+const (
+	DefaultRateEvery = 30 * time.Second
+	DefaultRateBurst = 3
+)
+
+// Limiter is a token bucket rate limit. A single Limiter can be shared by the
+// gRPC and HTTP servers, so that both count against the same limit.
+type Limiter struct {
 	rl *rate.Limiter
 }
 
-func (rl *rateLimiter) Limit() bool {
-	return rl.rl.Allow()
+// NewLimiter returns a limiter allowing a request every given duration, with
+// bursts of up to burst requests.
+func NewLimiter(every time.Duration, burst int) *Limiter {
+	return &Limiter{rl: rate.NewLimiter(rate.Every(every), burst)}
+}
+
+// SetRate changes the rate of the limiter, for example when the configuration
+// is reloaded.
+func (rl *Limiter) SetRate(every time.Duration, burst int) {
+	rl.rl.SetLimit(rate.Every(every))
+	rl.rl.SetBurst(burst)
 }
 
-func NewServer(ctx context.Context, capGen *Generator) *grpc.Server {
-	limiter := &rateLimiter{
-		rl: rate.NewLimiter(rate.Every(time.Second * 30), 3), // note that a captcha's TTL is also 30 seconds
+// Rate returns the current rate of the limiter.
+func (rl *Limiter) Rate() (every time.Duration, burst int) {
+	if l := rl.rl.Limit(); l > 0 && l != rate.Inf {
+		every = time.Duration(float64(time.Second) / float64(l))
 	}
+	return every, rl.rl.Burst()
+}
+
+// Limit returns true if the request must be rejected.
+func (rl *Limiter) Limit() bool {
+	return !rl.rl.Allow()
+}
+
+// rateLimitInterceptor rejects unary Captcha calls with ResourceExhausted
+// when the rate limit of their tenant, or l, is exceeded. Health checks and
+// admin calls aren't limited. It must run after tenantUnaryInterceptor.
+func rateLimitInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	srv := captchaServer{limiter: l}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if captchaMethod(info.FullMethod) && srv.rateLimiter(ctx).Limit() {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s is rejected by rate limit, please retry later", info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ServerOption configures the servers returned by NewServer and
+// NewHTTPHandler.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	limiter    *Limiter
+	grpcOpts   []grpc.ServerOption
+	health     *health.Server
+	reflection bool
+	privileged map[string]bool
+	tenants    *Tenants
+	admin      bool
+	adminKey   string
+	// adminClients are the subject common names of the admin certificates.
+	adminClients map[string]bool
+}
+
+// WithLimiter sets the rate limit of the server. By default, every server
+// gets its own limiter with DefaultRateEvery and DefaultRateBurst.
+func WithLimiter(l *Limiter) ServerOption {
+	return func(o *serverOptions) {
+		o.limiter = l
+	}
+}
 
-	srv := grpc.NewServer(
+// WithGRPCOptions passes options, such as transport credentials, to the gRPC
+// server created by NewServer. NewHTTPHandler ignores them.
+func WithGRPCOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(o *serverOptions) {
+		o.grpcOpts = append(o.grpcOpts, opts...)
+	}
+}
+
+func newServerOptions(opts []ServerOption) *serverOptions {
+	o := new(serverOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.limiter == nil {
+		o.limiter = NewLimiter(DefaultRateEvery, DefaultRateBurst)
+	}
+	if o.health == nil {
+		o.health = health.NewServer()
+	}
+	return o
+}
+
+func NewServer(ctx context.Context, capGen *Generator, opts ...ServerOption) *grpc.Server {
+	o := newServerOptions(opts)
+
+	srv := grpc.NewServer(append([]grpc.ServerOption{
 		grpc_middleware.WithUnaryServerChain(
-			ratelimit.UnaryServerInterceptor(limiter),
+			otelgrpc.UnaryServerInterceptor(),
+			metricsUnaryInterceptor,
+			clientAuthUnaryInterceptor(o.privileged),
+			adminAuthInterceptor(o.adminKey, o.adminClients),
+			tenantUnaryInterceptor(o.tenants),
+			auditRateLimitInterceptor(capGen),
+			rateLimitInterceptor(o.limiter),
 		),
-	)
-	pb.RegisterCaptchaServer(srv, captchaServer{context: ctx, capGen: capGen})
+		grpc_middleware.WithStreamServerChain(
+			otelgrpc.StreamServerInterceptor(),
+			metricsStreamInterceptor,
+			clientAuthStreamInterceptor(o.privileged),
+			tenantStreamInterceptor(o.tenants),
+		),
+	}, o.grpcOpts...)...)
+	pb.RegisterCaptchaServer(srv, captchaServer{
+		context: ctx,
+		capGen:  capGen,
+		limiter: o.limiter,
+	})
+	if o.admin {
+		pb.RegisterCaptchaAdminServer(srv, adminServer{
+			capGen:  capGen,
+			tenants: o.tenants,
+			limiter: o.limiter,
+		})
+	}
+	healthpb.RegisterHealthServer(srv, o.health)
+	if o.reflection {
+		reflection.Register(srv)
+	}
 
 	return srv
 }
@@ -0,0 +1,188 @@
+package captcha
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// testServer returns a captcha server backed by DefaultGenerator, allowing
+// burst requests at once.
+func testServer(burst int) captchaServer {
+	return captchaServer{
+		context: context.Background(),
+		capGen:  DefaultGenerator(),
+		limiter: NewLimiter(time.Hour, burst),
+	}
+}
+
+// dialTestServer serves cs on an in-memory listener and returns a client
+// connected to it.
+func dialTestServer(t *testing.T, cs captchaServer) pb.CaptchaClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterCaptchaServer(srv, cs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewCaptchaClient(conn)
+}
+
+// receiveAll reads the batch until it ends, returning the challenges and the
+// error the stream ended with, if any.
+func receiveAll(stream pb.Captcha_GetBatchClient) (cs []*pb.Challenge, err error) {
+	for {
+		c, err := stream.Recv()
+		if err == io.EOF {
+			return cs, nil
+		}
+		if err != nil {
+			return cs, err
+		}
+		cs = append(cs, c)
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	cs := testServer(MaxBatch + 10)
+	client := dialTestServer(t, cs)
+	ctx := context.Background()
+
+	stream, err := client.GetBatch(ctx, &pb.BatchRequest{Count: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := receiveAll(stream)
+	if err != nil || len(got) != 5 {
+		t.Fatalf("expected 5 challenges, got %d (%v)", len(got), err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range got {
+		if seen[c.Id] || len(c.GrayPixels) == 0 || !c.ExpiresAt.AsTime().After(time.Now()) {
+			t.Errorf("bad challenge in batch: %q", c.Id)
+		}
+		seen[c.Id] = true
+	}
+
+	stream, err = client.GetBatch(ctx, &pb.BatchRequest{
+		User:  &pb.User{Type: pb.ChallengeType_PROOF_OF_WORK},
+		Count: MaxBatch + 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = receiveAll(stream)
+	if err != nil || len(got) != MaxBatch {
+		t.Errorf("expected batch capped at %d, got %d (%v)", MaxBatch, len(got), err)
+	}
+	for _, c := range got {
+		if c.Type != pb.ChallengeType_PROOF_OF_WORK || len(c.Prefix) == 0 {
+			t.Errorf("expected proof-of-work challenge, got %v", c)
+			break
+		}
+	}
+}
+
+func TestGetBatchRateLimit(t *testing.T) {
+	client := dialTestServer(t, testServer(3))
+	stream, err := client.GetBatch(context.Background(), &pb.BatchRequest{Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := receiveAll(stream)
+	if len(got) != 3 || status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected 3 challenges and ResourceExhausted, got %d and %v", len(got), err)
+	}
+
+	stream, err = client.GetBatch(context.Background(), &pb.BatchRequest{})
+	if err == nil {
+		_, err = receiveAll(stream)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for empty batch, got %v", err)
+	}
+}
+
+// cancelingStream is a GetBatch stream whose client goes away after it
+// received the given number of challenges.
+type cancelingStream struct {
+	pb.Captcha_GetBatchServer
+	ctx    context.Context
+	cancel context.CancelFunc
+	after  int
+	sent   int
+}
+
+func (s *cancelingStream) Context() context.Context { return s.ctx }
+
+func (s *cancelingStream) Send(*pb.Challenge) error {
+	if s.sent++; s.sent == s.after {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestGetBatchCancel(t *testing.T) {
+	cs := testServer(MaxBatch)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &cancelingStream{ctx: ctx, cancel: cancel, after: 3}
+
+	err := cs.GetBatch(&pb.BatchRequest{Count: 10}, stream)
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("expected Canceled, got %v", err)
+	}
+	if stream.sent != 3 {
+		t.Errorf("sent %d challenges after cancellation, expected 3", stream.sent)
+	}
+	if n, _ := store.Len(cs.capGen.CacheStore); n != 3 {
+		t.Errorf("stored %d challenges, expected 3", n)
+	}
+}
+
+func TestValidatePoWDifficulty(t *testing.T) {
+	cs := testServer(100)
+	ctx := context.Background() // no peer: clients are keyed by user id
+	user := &pb.User{Id: "alice", Type: pb.ChallengeType_PROOF_OF_WORK}
+
+	first, err := cs.Get(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		c, err := cs.Get(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st, err := cs.Validate(ctx, &pb.Solution{Id: c.Id, Code: "wrong", Type: c.Type}); err != nil || st.Code != 400 {
+			t.Fatalf("wrong solution: %v %v", st, err)
+		}
+	}
+	next, err := cs.Get(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := first.Difficulty + 2*powFailureBits; next.Difficulty != want {
+		t.Errorf("difficulty after 2 failures: got %d, expected %d", next.Difficulty, want)
+	}
+	if other, _ := cs.Get(ctx, &pb.User{Id: "bob", Type: pb.ChallengeType_PROOF_OF_WORK}); other.Difficulty != first.Difficulty {
+		t.Errorf("another user's difficulty raised to %d", other.Difficulty)
+	}
+}
@@ -0,0 +1,130 @@
+package captcha
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"github.com/roachapp/captcha/pkg/util"
+	"io"
+)
+
+// Image captchas are never stored as plain digits. A record holds a keyed hash
+// of the solution, which is all Verify needs, and a sealed copy of the digits
+// for WriteImage and Reload, along with the id of the RNG key the image is
+// rendered with:
+//
+//   record = imageTag || HMAC(hashKey, id || 0x00 || digits) || nonce ||
+//            AES-GCM(sealKey, nonce, len(keyID) || keyID || digits,
+//                    additional data = id)
+//
+// Both keys are derived from Generator.Secret.
+const (
+	// imageTag marks image captcha records in the store.
+	imageTag = 0xfe
+	// Length of the solution hash.
+	hashLen = sha256.Size
+	// Length of the secret generated when Generator.Secret is empty.
+	secretLen = 32
+)
+
+// Purposes for key derivation from Generator.Secret.
+const (
+	hashKeyPurpose = "captcha solution hash"
+	sealKeyPurpose = "captcha solution seal"
+)
+
+// deriveKey returns a 32-byte key for the given purpose.
+//
+//   out = HMAC(secret, purpose)
+//
+func deriveKey(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	io.WriteString(h, purpose)
+	return h.Sum(nil)
+}
+
+// initKeys derives the hash and seal keys from the secret. If no secret was
+// configured, a random one is used, which means solutions don't survive a
+// restart and can't be shared between replicas.
+func (g *Generator) initKeys() {
+	secret := g.Secret
+	if len(secret) == 0 {
+		secret = util.RandomBytes(secretLen)
+	}
+	g.hashKey = deriveKey(secret, hashKeyPurpose)
+	block, err := aes.NewCipher(deriveKey(secret, sealKeyPurpose))
+	if err != nil {
+		panic("captcha: error creating cipher: " + err.Error())
+	}
+	if g.sealer, err = cipher.NewGCM(block); err != nil {
+		panic("captcha: error creating cipher: " + err.Error())
+	}
+}
+
+// solutionHash returns the keyed hash of the digits for the given id.
+func (g *Generator) solutionHash(id string, digits []byte) []byte {
+	g.keysOnce.Do(g.initKeys)
+	h := hmac.New(sha256.New, g.hashKey)
+	io.WriteString(h, id)
+	h.Write([]byte{0})
+	h.Write(digits)
+	return h.Sum(nil)
+}
+
+// seal returns the record to store for the given id and digits, rendered with
+// the RNG key that has the given id.
+func (g *Generator) seal(id string, digits []byte, keyID string) []byte {
+	g.keysOnce.Do(g.initKeys)
+	if len(keyID) > 255 {
+		panic("captcha: rng key id too long")
+	}
+	plain := make([]byte, 0, 1+len(keyID)+len(digits))
+	plain = append(plain, byte(len(keyID)))
+	plain = append(plain, keyID...)
+	plain = append(plain, digits...)
+
+	nonce := util.RandomBytes(g.sealer.NonceSize())
+	rec := make([]byte, 0, 1+hashLen+len(nonce)+len(plain)+g.sealer.Overhead())
+	rec = append(rec, imageTag)
+	rec = append(rec, g.solutionHash(id, digits)...)
+	rec = append(rec, nonce...)
+	return g.sealer.Seal(rec, nonce, plain, []byte(id))
+}
+
+// open returns the digits and the RNG key id sealed in the record for the
+// given id. Digits are nil if the record is not a valid image captcha record
+// for this id.
+func (g *Generator) open(id string, rec []byte) (digits []byte, keyID string) {
+	g.keysOnce.Do(g.initKeys)
+	ns := g.sealer.NonceSize()
+	if len(rec) < 1+hashLen+ns || rec[0] != imageTag {
+		return nil, ""
+	}
+	nonce := rec[1+hashLen : 1+hashLen+ns]
+	plain, err := g.sealer.Open(nil, nonce, rec[1+hashLen+ns:], []byte(id))
+	if err != nil || len(plain) < 1 || len(plain) < 1+int(plain[0]) {
+		return nil, ""
+	}
+	n := 1 + int(plain[0])
+	return plain[n:], string(plain[1:n])
+}
+
+// dummyRecord stands in for missing or malformed records, so that verifying
+// them does the same work as verifying a real one.
+var dummyRecord = make([]byte, 1+hashLen)
+
+// matches returns 1 if the record for the given id holds the hash of the given
+// digits and 0 otherwise. It takes the same time whatever the record and the
+// digits are.
+func (g *Generator) matches(id string, rec, digits []byte) int {
+	valid := 1
+	if len(rec) < 1+hashLen {
+		rec, valid = dummyRecord, 0
+	}
+	sum := g.solutionHash(id, digits)
+	return valid &
+		subtle.ConstantTimeByteEq(rec[0], imageTag) &
+		subtle.ConstantTimeCompare(rec[1:1+hashLen], sum)
+}
@@ -0,0 +1,245 @@
+package captcha
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sort"
+	"strings"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// APIKeyHeader is the gRPC metadata key and HTTP header carrying the API key
+// of a tenant. HTTP requests that can't set headers, like the ones of images
+// and of the widget, pass it in the apiKeyParam query parameter instead.
+const APIKeyHeader = "x-api-key"
+
+const apiKeyParam = "api_key"
+
+var (
+	errNoAPIKey      = errors.New("an API key is required")
+	errUnknownAPIKey = errors.New("unknown API key")
+)
+
+// Tenant is a product served by a shared captcha service, with its own
+// settings and challenges.
+type Tenant struct {
+	Name string
+	// Generator issues and validates the tenant's challenges. Create it with
+	// ForTenant, so that the tenant's ids are kept apart from the others'.
+	Generator *Generator
+	// Limiter, if set, rate limits the tenant's requests instead of the
+	// server's limiter.
+	Limiter *Limiter
+}
+
+// Tenants maps API keys to tenants. It's filled before the servers start,
+// and must not be changed afterwards.
+type Tenants struct {
+	byKey  map[[sha256.Size]byte]*Tenant
+	byName map[string]*Tenant
+}
+
+// NewTenants returns an empty set of tenants.
+func NewTenants() *Tenants {
+	return &Tenants{
+		byKey:  make(map[[sha256.Size]byte]*Tenant),
+		byName: make(map[string]*Tenant),
+	}
+}
+
+// Add registers the tenant with the given API keys. Names and keys must be
+// unique, and names can't contain "/", which separates them from the ids in
+// the stores.
+func (ts *Tenants) Add(t *Tenant, apiKeys ...string) error {
+	switch {
+	case t.Name == "":
+		return errors.New("captcha: tenant without a name")
+	case strings.Contains(t.Name, "/"):
+		return fmt.Errorf("captcha: tenant name %q contains \"/\"", t.Name)
+	case t.Generator == nil:
+		return fmt.Errorf("captcha: tenant %q without a generator", t.Name)
+	case len(apiKeys) == 0:
+		return fmt.Errorf("captcha: tenant %q without API keys", t.Name)
+	case ts.byName[t.Name] != nil:
+		return fmt.Errorf("captcha: duplicate tenant %q", t.Name)
+	}
+	// Check every key before adding any, so that a failed Add changes nothing.
+	hashes := make([][sha256.Size]byte, len(apiKeys))
+	for i, k := range apiKeys {
+		hashes[i] = sha256.Sum256([]byte(k))
+		if k == "" {
+			return fmt.Errorf("captcha: empty API key for tenant %q", t.Name)
+		}
+		if other := ts.byKey[hashes[i]]; other != nil {
+			return fmt.Errorf("captcha: API key of tenant %q already used by %q", t.Name, other.Name)
+		}
+	}
+	for _, h := range hashes {
+		ts.byKey[h] = t
+	}
+	ts.byName[t.Name] = t
+	return nil
+}
+
+// Lookup returns the tenant with the given API key, or nil. Keys are looked up
+// by hash, so that the time taken doesn't tell how much of a key is right.
+func (ts *Tenants) Lookup(apiKey string) *Tenant {
+	return ts.byKey[sha256.Sum256([]byte(apiKey))]
+}
+
+// Get returns the tenant with the given name, or nil.
+func (ts *Tenants) Get(name string) *Tenant {
+	return ts.byName[name]
+}
+
+// All returns the tenants sorted by name.
+func (ts *Tenants) All() []*Tenant {
+	all := make([]*Tenant, 0, len(ts.byName))
+	for _, t := range ts.byName {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// resolve returns the tenant with the given API key, or an error if the key is
+// missing or unknown.
+func (ts *Tenants) resolve(apiKey string) (*Tenant, error) {
+	if apiKey == "" {
+		return nil, errNoAPIKey
+	}
+	if t := ts.Lookup(apiKey); t != nil {
+		return t, nil
+	}
+	return nil, errUnknownAPIKey
+}
+
+// WithTenants serves the given tenants: every request to the Captcha service
+// must carry the API key of one of them, and is served by its Generator and
+// Limiter. Other requests are rejected with Unauthenticated, or 401
+// Unauthorized over HTTP.
+func WithTenants(ts *Tenants) ServerOption {
+	return func(o *serverOptions) {
+		o.tenants = ts
+	}
+}
+
+type tenantKey struct{}
+
+// tenantFromContext returns the tenant of the request of ctx, or nil.
+func tenantFromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// ForTenant returns a generator for the named tenant, with the settings,
+// secret, stores and audit sink of g. Its ids are prefixed with the tenant's
+// name in the stores, so that a challenge issued for a tenant can't be seen
+// or validated through another. Its settings can be changed before it's used.
+// It shares the stores of g, so only g must be closed.
+func (g *Generator) ForTenant(name string) *Generator {
+	return &Generator{
+		DigitLen:       g.DigitLen,
+		Width:          g.Width,
+		Height:         g.Height,
+		Color:          g.Color,
+		PoWDifficulty:  g.PoWDifficulty,
+		TTL:            g.TTL,
+		PoWTTL:         g.PoWTTL,
+		AnonymousTTL:   g.AnonymousTTL,
+		Secret:         g.Secret,
+		CacheStore:     g.CacheStore,
+		PgStore:        g.PgStore,
+		PersistentTier: g.PersistentTier,
+		Audit:          g.Audit,
+		Tenant:         name,
+	}
+}
+
+// storeKey returns the key of the captcha with the given id in the stores.
+// Tenant names can't contain "/", so that the key of an id sent to a tenant
+// can't be the key of another tenant's challenge, like "a/b/<id>" for the id
+// "b/<id>" of tenant "a" and the challenge <id> of tenant "a/b".
+func (g *Generator) storeKey(id string) string {
+	if g.Tenant == "" {
+		return id
+	}
+	return g.Tenant + "/" + id
+}
+
+// captchaMethod returns true if the full gRPC method name belongs to the
+// Captcha service.
+func captchaMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.Captcha_ServiceDesc.ServiceName+"/")
+}
+
+// tenantContext resolves the API key in the metadata of ctx, and returns ctx
+// with its tenant.
+func (ts *Tenants) tenantContext(ctx context.Context) (context.Context, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(APIKeyHeader); len(v) > 0 {
+			key = v[0]
+		}
+	}
+	t, err := ts.resolve(key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, tenantKey{}, t), nil
+}
+
+// tenantUnaryInterceptor attaches the tenant of the API key to the context of
+// Captcha calls, and rejects the calls without a valid key. It does nothing if
+// ts is nil.
+func tenantUnaryInterceptor(ts *Tenants) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if ts == nil || !captchaMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := ts.tenantContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tenantStreamInterceptor is tenantUnaryInterceptor for streams.
+func tenantStreamInterceptor(ts *Tenants) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ts == nil || !captchaMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := ts.tenantContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+// tenantHTTPContext is tenantContext for HTTP requests, with the key in the
+// APIKeyHeader header or the apiKeyParam query parameter.
+func (ts *Tenants) tenantHTTPContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		key = r.URL.Query().Get(apiKeyParam)
+	}
+	t, err := ts.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, tenantKey{}, t), nil
+}
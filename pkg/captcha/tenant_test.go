@@ -0,0 +1,169 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// testTenants returns a shop tenant with 5-digit captchas and a forum tenant
+// allowing a single request, sharing the stores of g.
+func testTenants(t *testing.T, g *Generator) (ts *Tenants, shop, forum *Tenant) {
+	shop = &Tenant{Name: "shop", Generator: g.ForTenant("shop"), Limiter: NewLimiter(time.Hour, 100)}
+	shop.Generator.DigitLen = 5
+	forum = &Tenant{Name: "forum", Generator: g.ForTenant("forum"), Limiter: NewLimiter(time.Hour, 1)}
+	ts = NewTenants()
+	if err := ts.Add(shop, "shop-1", "shop-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Add(forum, "forum-1"); err != nil {
+		t.Fatal(err)
+	}
+	return ts, shop, forum
+}
+
+func TestTenantsAdd(t *testing.T) {
+	ts, shop, _ := testTenants(t, DefaultGenerator())
+	if ts.Lookup("shop-2") != shop || ts.Lookup("shop") != nil || ts.Get("shop") != shop {
+		t.Errorf("lookup failed")
+	}
+	other := &Tenant{Name: "other", Generator: DefaultGenerator()}
+	for _, test := range []struct {
+		tenant *Tenant
+		keys   []string
+		want   string
+	}{
+		{shop, []string{"new"}, "duplicate tenant"},
+		{other, nil, "without API keys"},
+		{other, []string{"other-1", "forum-1"}, `already used by "forum"`},
+		{other, []string{""}, "empty API key"},
+		{&Tenant{Generator: DefaultGenerator()}, []string{"k"}, "without a name"},
+		// Tenant "shop" could consume the challenges of "shop/eu" with ids
+		// like "eu/<id>".
+		{&Tenant{Name: "shop/eu", Generator: DefaultGenerator()}, []string{"k"}, `contains "/"`},
+	} {
+		if err := ts.Add(test.tenant, test.keys...); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Add(%q, %q): got %v, expected %q", test.tenant.Name, test.keys, err, test.want)
+		}
+	}
+	if ts.Lookup("other-1") != nil || ts.Get("other") != nil {
+		t.Errorf("failed Add registered the tenant")
+	}
+	if all := ts.All(); len(all) != 2 || all[0].Name != "forum" || all[1].Name != "shop" {
+		t.Errorf("All: unexpected tenants %v", all)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	g := DefaultGenerator()
+	ts, shop, forum := testTenants(t, g)
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(context.Background(), g, WithLimiter(NewLimiter(time.Hour, 100)), WithTenants(ts))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewCaptchaClient(conn)
+	ctx := context.Background()
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, APIKeyHeader, key)
+	}
+
+	if _, err := client.Get(ctx, &pb.User{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Get without a key: expected Unauthenticated, got %v", err)
+	}
+	if _, err := client.Get(withKey("nope"), &pb.User{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Get with an unknown key: expected Unauthenticated, got %v", err)
+	}
+	stream, err := client.GetBatch(ctx, &pb.BatchRequest{Count: 1})
+	if err == nil {
+		_, err = receiveAll(stream)
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetBatch without a key: expected Unauthenticated, got %v", err)
+	}
+
+	c, err := client.Get(withKey("shop-1"), &pb.User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digits := shop.Generator.digits(ctx, c.Id)
+	if len(digits) != 5 {
+		t.Fatalf("expected a 5-digit captcha of the shop, got %v", digits)
+	}
+	if g.digits(ctx, c.Id) != nil || forum.Generator.digits(ctx, c.Id) != nil {
+		t.Errorf("captcha of the shop visible to other generators")
+	}
+	sol := &pb.Solution{Id: c.Id, Code: solutionString(digits)}
+	if st, err := client.Validate(withKey("forum-1"), sol); err != nil || st.Code != 400 {
+		t.Errorf("shop captcha validated through the forum: %v %v", st, err)
+	}
+	if st, err := client.Validate(withKey("shop-2"), sol); err != nil || st.Code != 200 {
+		t.Errorf("shop captcha not validated through the shop: %v %v", st, err)
+	}
+
+	// The forum's limit was used by the validation above; the shop's wasn't.
+	if _, err := client.Get(withKey("forum-1"), &pb.User{}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the forum's limit to be exceeded, got %v", err)
+	}
+	if _, err := client.Get(withKey("shop-1"), &pb.User{}); err != nil {
+		t.Errorf("shop limited by the forum's limit: %v", err)
+	}
+}
+
+func TestTenantHTTP(t *testing.T) {
+	g := DefaultGenerator()
+	ts, shop, _ := testTenants(t, g)
+	h := NewHTTPHandler(context.Background(), g, WithTenants(ts))
+
+	if w := postJSON(t, h, HTTPPrefix+"new", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("create without a key: expected 401, got %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HTTPPrefix+"widget.js", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("widget needs no key, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, HTTPPrefix+"new", nil)
+	r.Header.Set(APIKeyHeader, "shop-1")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var c httpChallenge
+	if err := json.Unmarshal(w.Body.Bytes(), &c); w.Code != http.StatusCreated || err != nil {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if shop.Generator.digits(context.Background(), c.Id) == nil {
+		t.Errorf("captcha not issued by the shop's generator")
+	}
+
+	for key, want := range map[string]int{
+		"shop-2":  http.StatusOK,
+		"forum-1": http.StatusNotFound,
+		"":        http.StatusUnauthorized,
+	} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.Image+"?api_key="+key, nil))
+		if w.Code != want {
+			t.Errorf("image with key %q: expected %d, got %d", key, want, w.Code)
+		}
+	}
+}
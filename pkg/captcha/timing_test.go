@@ -0,0 +1,85 @@
+package captcha
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The timing test is too sensitive to machine noise to run by default. Set
+// CAPTCHA_TIMING_TEST=1 to run it, preferably on an otherwise idle machine.
+const (
+	timingSamples = 20000
+	// Welch's t-statistic above which the difference between two timing
+	// distributions is considered significant (as used by dudect).
+	timingMaxT = 4.5
+	// Differences between trimmed means smaller than this fraction are
+	// ignored, however significant they are.
+	timingMaxRelDiff = 0.05
+)
+
+// timingStats returns the mean and variance of the samples with the slowest
+// 10% trimmed off, to keep scheduler hiccups from dominating the result.
+func timingStats(samples []float64) (mean, variance float64, n int) {
+	s := append([]float64(nil), samples...)
+	sort.Float64s(s)
+	s = s[:len(s)*9/10]
+	for _, v := range s {
+		mean += v
+	}
+	mean /= float64(len(s))
+	for _, v := range s {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(s) - 1)
+	return mean, variance, len(s)
+}
+
+// welchT returns Welch's t-statistic and the relative difference of the
+// trimmed means of two sets of samples.
+func welchT(a, b []float64) (t, rel float64) {
+	ma, va, na := timingStats(a)
+	mb, vb, nb := timingStats(b)
+	t = (ma - mb) / math.Sqrt(va/float64(na)+vb/float64(nb))
+	return t, math.Abs(ma-mb) / math.Min(ma, mb)
+}
+
+func TestVerifyTiming(t *testing.T) {
+	if os.Getenv("CAPTCHA_TIMING_TEST") == "" {
+		t.Skip("set CAPTCHA_TIMING_TEST=1 to run the timing test")
+	}
+	ctx := context.Background()
+	g := DefaultGenerator()
+
+	cases := []string{"hit", "miss", "wrong"}
+	samples := make(map[string][]float64)
+	for i := 0; i < timingSamples; i++ {
+		// Interleave the cases so that drift affects all of them equally.
+		for _, c := range cases {
+			id := g.New(ctx)
+			d := g.digits(ctx, id)
+			switch c {
+			case "miss":
+				id = id[1:] + "x"
+			case "wrong":
+				d = append([]byte(nil), d...)
+				d[len(d)-1] = (d[len(d)-1] + 1) % 10
+			}
+			start := time.Now()
+			g.Verify(ctx, id, d)
+			samples[c] = append(samples[c], float64(time.Since(start)))
+		}
+	}
+
+	for _, pair := range [][2]string{{"hit", "miss"}, {"hit", "wrong"}, {"miss", "wrong"}} {
+		tv, rel := welchT(samples[pair[0]], samples[pair[1]])
+		t.Logf("%s vs %s: t = %.2f, relative difference = %.3f", pair[0], pair[1], tv, rel)
+		if math.Abs(tv) > timingMaxT && rel > timingMaxRelDiff {
+			t.Errorf("%s and %s timings differ: t = %.2f, relative difference = %.3f",
+				pair[0], pair[1], tv, rel)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package captcha
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PrivilegedMethods are the RPCs reserved to backend services when client
+// certificates are verified: public clients can get challenges, but only
+// backends can tell whether a solution is right.
+var PrivilegedMethods = []string{
+	"/api.Captcha/Validate",
+}
+
+// certCheckInterval is how often a CertReloader looks for changed files.
+var certCheckInterval = 10 * time.Second
+
+// CertReloader serves a certificate from a pair of PEM files, and reloads it
+// when the files change, so that renewed certificates are picked up without
+// a restart. If a reload fails, the previous certificate is kept.
+type CertReloader struct {
+	certFile, keyFile string
+
+	sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader loads the certificate and key from the given files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the files if they changed since the last load.
+func (r *CertReloader) load() error {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the
+// files changed. It's meant for tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+	if now := time.Now(); now.Sub(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = now
+		old := r.cert
+		if err := r.load(); err != nil {
+			log.Errorf("failed to reload TLS certificate, keeping the current one: %v", err)
+		} else if r.cert != old {
+			log.Infof("reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+func latestModTime(names ...string) (t time.Time, err error) {
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return t, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
+
+// ServerTLSConfig returns the TLS configuration of a server with the
+// certificate in the given files, reloaded when they change. If clientCAFile
+// is set, client certificates are verified against the CAs in it; they stay
+// optional at the TLS level, and are required for privileged methods with
+// WithClientAuth.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+		}
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return c, nil
+}
+
+// WithClientAuth requires a client certificate verified by the server's
+// client CAs to call the given methods, full gRPC method names like
+// PrivilegedMethods. Other calls are rejected with PermissionDenied, and the
+// HTTP server rejects validations with 403 Forbidden unless Validate is left
+// out.
+func WithClientAuth(methods ...string) ServerOption {
+	return func(o *serverOptions) {
+		if o.privileged == nil {
+			o.privileged = make(map[string]bool)
+		}
+		for _, m := range methods {
+			o.privileged[m] = true
+		}
+	}
+}
+
+var errNoClientCert = errors.New("a verified client certificate is required")
+
+// verifiedClient returns true if the peer of ctx presented a certificate that
+// the server verified.
+func verifiedClient(ctx context.Context) bool {
+	_, ok := verifiedClientName(ctx)
+	return ok
+}
+
+// verifiedClientName returns the subject common name of the certificate the
+// peer of ctx presented, if the server verified it.
+func verifiedClientName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return "", false
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// verifiedHTTPClient is verifiedClient for HTTP requests.
+func verifiedHTTPClient(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// clientAuthUnaryInterceptor rejects calls of privileged methods by clients
+// without a verified certificate.
+func clientAuthUnaryInterceptor(privileged map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if privileged[info.FullMethod] && !verifiedClient(ctx) {
+			return nil, status.Error(codes.PermissionDenied, errNoClientCert.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// clientAuthStreamInterceptor is clientAuthUnaryInterceptor for streams.
+func clientAuthStreamInterceptor(privileged map[string]bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if privileged[info.FullMethod] && !verifiedClient(ss.Context()) {
+			return status.Error(codes.PermissionDenied, errNoClientCert.Error())
+		}
+		return handler(srv, ss)
+	}
+}
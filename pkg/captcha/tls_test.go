@@ -0,0 +1,254 @@
+package captcha
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// testCert is a certificate signed by a test CA, or self-signed if ca is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+var serial int64
+
+func newTestCert(t *testing.T, ca *testCert, name string, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, parentKey := tmpl, key
+	if ca != nil {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and key as PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "captcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestCertReloader(t *testing.T) {
+	defer func(d time.Duration) { certCheckInterval = d }(certCheckInterval)
+	certCheckInterval = 0
+	dir := tempDir(t)
+
+	first := newTestCert(t, nil, "first", false)
+	certFile, keyFile := first.write(t, dir, "server")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(c.Certificate[0]); leaf.Subject.CommonName != "first" {
+		t.Fatalf("unexpected certificate %s", leaf.Subject.CommonName)
+	}
+
+	second := newTestCert(t, nil, "second", false)
+	second.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	c, _ = r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(c.Certificate[0]); leaf.Subject.CommonName != "second" {
+		t.Errorf("certificate not reloaded, got %s", leaf.Subject.CommonName)
+	}
+
+	// A broken file keeps the current certificate.
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	c, err = r.GetCertificate(nil)
+	if err != nil || c == nil {
+		t.Fatalf("broken reload: %v", err)
+	}
+	if leaf, _ := x509.ParseCertificate(c.Certificate[0]); leaf.Subject.CommonName != "second" {
+		t.Errorf("certificate replaced by a broken one")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCert(t, nil, "ca", true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, ca, "server", false).write(t, dir, "server")
+	backend := newTestCert(t, ca, "backend", false)
+	stranger := newTestCert(t, nil, "stranger", false)
+
+	tlsConfig, err := ServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := DefaultGenerator()
+	ops := newTestCert(t, ca, "ops", false)
+	srv := NewServer(context.Background(), g,
+		WithLimiter(NewLimiter(time.Hour, 100)),
+		WithGRPCOptions(grpc.Creds(credentials.NewTLS(tlsConfig))),
+		WithClientAuth(PrivilegedMethods...),
+		WithAdmin("", "ops"),
+	)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dialConn := func(certs ...tls.Certificate) *grpc.ClientConn {
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: certs})
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	dial := func(certs ...tls.Certificate) pb.CaptchaClient {
+		return pb.NewCaptchaClient(dialConn(certs...))
+	}
+
+	ctx := context.Background()
+	public := dial()
+	if _, err := public.Get(ctx, &pb.User{}); err != nil {
+		t.Errorf("public Get: %v", err)
+	}
+	if _, err := public.Validate(ctx, &pb.Solution{Id: g.New(ctx), Code: "000"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("public Validate: expected PermissionDenied, got %v", err)
+	}
+	if _, err := dial(backend.tlsCertificate()).Validate(ctx, &pb.Solution{Id: g.New(ctx), Code: "000"}); err != nil {
+		t.Errorf("backend Validate: %v", err)
+	}
+	// Clients only offer certificates issued by one of the server's client CAs,
+	// so unknown ones are treated like public clients.
+	if _, err := dial(stranger.tlsCertificate()).Validate(ctx, &pb.Solution{Id: g.New(ctx), Code: "000"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("stranger Validate: expected PermissionDenied, got %v", err)
+	}
+
+	// Only the admin certificates of the CA give access to the admin service.
+	for cert, want := range map[*testCert]codes.Code{
+		ops:     codes.OK,
+		backend: codes.Unauthenticated,
+	} {
+		admin := pb.NewCaptchaAdminClient(dialConn(cert.tlsCertificate()))
+		if _, err := admin.CountChallenges(ctx, new(emptypb.Empty)); status.Code(err) != want {
+			t.Errorf("%s: expected %s, got %v", cert.cert.Subject.CommonName, want, err)
+		}
+	}
+}
+
+func TestHTTPClientAuth(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCert(t, nil, "ca", true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, ca, "server", false).write(t, dir, "server")
+	backend := newTestCert(t, ca, "backend", false)
+
+	tlsConfig, err := ServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := DefaultGenerator()
+	// Served like cmd/main.go does.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: NewHTTPHandler(context.Background(), g,
+		WithLimiter(NewLimiter(time.Hour, 100)),
+		WithClientAuth(PrivilegedMethods...),
+	)}
+	go srv.Serve(tls.NewListener(lis, tlsConfig))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	validate := func(certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		body := `{"id":"` + g.New(context.Background()) + `","code":"000"}`
+		resp, err := client.Post("https://"+lis.Addr().String()+HTTPPrefix+"validate", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := validate(); code != http.StatusForbidden {
+		t.Errorf("public validate: expected 403, got %d", code)
+	}
+	if code := validate(backend.tlsCertificate()); code != http.StatusOK {
+		t.Errorf("backend validate: expected 200, got %d", code)
+	}
+}
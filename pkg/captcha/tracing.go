@@ -0,0 +1,49 @@
+package captcha
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/store"
+	"github.com/roachapp/captcha/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Tier names of the stores, in spans.
+const (
+	cacheTier = "cache"
+	pgTier    = "postgres"
+)
+
+// persistentTier returns the tier name of PgStore.
+func (g *Generator) persistentTier() string {
+	if g.PersistentTier == "" {
+		return pgTier
+	}
+	return g.PersistentTier
+}
+
+// storeSet saves the record in s until expiresAt, in a span of the given tier.
+func storeSet(ctx context.Context, tier string, s store.Store, id string, rec []byte, expiresAt time.Time) {
+	ctx, span := tracing.Tracer().Start(ctx, "store.Set", trace.WithAttributes(
+		attribute.String("captcha.tier", tier),
+	))
+	defer span.End()
+	s.Set(ctx, id, rec, expiresAt)
+}
+
+// storeGet returns the record from s and its expiration time, in a span of
+// the given tier.
+func storeGet(ctx context.Context, tier string, s store.Store, id string, clear bool) ([]byte, time.Time) {
+	ctx, span := tracing.Tracer().Start(ctx, "store.Get", trace.WithAttributes(
+		attribute.String("captcha.tier", tier),
+		attribute.Bool("captcha.clear", clear),
+	))
+	defer span.End()
+	rec, expiresAt := s.Get(ctx, id, clear)
+	span.SetAttributes(
+		attribute.Bool("captcha.found", rec != nil),
+		attribute.Bool("captcha.expired", rec == nil && !expiresAt.IsZero()),
+	)
+	return rec, expiresAt
+}
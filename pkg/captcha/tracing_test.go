@@ -0,0 +1,73 @@
+package captcha
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// recordSpans installs a global tracer provider that records every span in
+// the returned exporter.
+func recordSpans() *tracetest.InMemoryExporter {
+	exp := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	return exp
+}
+
+func TestTracing(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	exp := recordSpans()
+
+	g := DefaultGenerator()
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(context.Background(), g, WithLimiter(NewLimiter(time.Hour, 10)))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := pb.NewCaptchaClient(conn).Get(context.Background(), &pb.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	byName := make(map[string]int)
+	for i, s := range spans {
+		byName[s.Name] = i
+	}
+	parents := map[string]string{
+		"captcha.Issue": "api.Captcha/Get",
+		"util.NewImage": "captcha.Issue",
+		"png.Encode":    "captcha.Issue",
+		"store.Set":     "captcha.Issue",
+	}
+	for name, parent := range parents {
+		i, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span in %d spans", name, len(spans))
+			continue
+		}
+		j, ok := byName[parent]
+		if !ok {
+			t.Errorf("no %s span", parent)
+			continue
+		}
+		if spans[i].Parent.SpanID() != spans[j].SpanContext.SpanID() {
+			t.Errorf("%s span isn't a child of %s", name, parent)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package captcha
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Names of the form fields written by the widget and read by
+// RequireSolution.
+const (
+	FormIdField       = "captcha_id"
+	FormSolutionField = "captcha_solution"
+)
+
+// widgetJS is served as HTTPPrefix + "widget.js". It turns every element with
+// a data-captcha attribute into a captcha widget:
+//
+//   <form method="post" action="/signup">
+//     <div data-captcha></div>
+//     <script src="https://captcha.example.com/captcha/widget.js"></script>
+//   </form>
+//
+// On a service with tenants, the script tag carries the tenant's API key in a
+// data-api-key attribute, which the widget passes in its requests:
+//
+//   <script src="https://captcha.example.com/captcha/widget.js" data-api-key="..."></script>
+//
+// The widget creates a challenge on the server the script was loaded from,
+// shows its image with a reload button, and writes the captcha id into a
+// hidden FormIdField next to a FormSolutionField text input. Expired
+// challenges are replaced by new ones, since reloading doesn't extend them.
+// There is no audio link: this fork doesn't serve audio captchas.
+const widgetJS = `(function () {
+  "use strict";
+  var script = document.currentScript;
+  var base = new URL("./", script.src).href;
+  var apiKey = script.getAttribute("data-api-key");
+
+  // withKey adds the API key to a URL. It's passed in the query rather than
+  // a header, so that images can be loaded and requests need no preflight.
+  function withKey(url) {
+    if (!apiKey) { return url; }
+    return url + (url.indexOf("?") < 0 ? "?" : "&") + "{{key}}=" + encodeURIComponent(apiKey);
+  }
+
+  function el(tag, attrs) {
+    var e = document.createElement(tag);
+    for (var k in attrs) { e.setAttribute(k, attrs[k]); }
+    return e;
+  }
+
+  function mount(root) {
+    var img = el("img", {"class": "captcha-image", "alt": "captcha"});
+    var id = el("input", {"type": "hidden", "name": "{{id}}"});
+    var input = el("input", {
+      "type": "text", "name": "{{solution}}", "class": "captcha-solution",
+      "autocomplete": "off", "inputmode": "numeric", "required": ""
+    });
+    var reload = el("button", {"type": "button", "class": "captcha-reload"});
+    reload.textContent = "Reload";
+
+    root.appendChild(img);
+    root.appendChild(reload);
+    root.appendChild(id);
+    root.appendChild(input);
+
+    var expiry;
+    function create() {
+      clearTimeout(expiry);
+      fetch(withKey(base + "new"), {method: "POST"})
+        .then(function (r) {
+          if (!r.ok) { throw new Error("captcha: " + r.status); }
+          return r.json();
+        })
+        .then(function (c) {
+          id.value = c.id;
+          img.width = c.width;
+          img.height = c.height;
+          img.src = withKey(new URL(c.image, base).href);
+          input.value = "";
+          var ttl = Date.parse(c.expires_at) - Date.now();
+          if (ttl > 0) { expiry = setTimeout(create, ttl); }
+        })
+        .catch(function (err) { root.setAttribute("data-captcha-error", err.message); });
+    }
+
+    reload.addEventListener("click", function () {
+      if (!id.value) { return create(); }
+      img.src = withKey(base + id.value + ".png?reload=" + Date.now());
+      input.value = "";
+    });
+    create();
+  }
+
+  var roots = document.querySelectorAll("[data-captcha]");
+  for (var i = 0; i < roots.length; i++) { mount(roots[i]); }
+})();
+`
+
+var widgetScript = strings.NewReplacer(
+	"{{id}}", FormIdField,
+	"{{solution}}", FormSolutionField,
+	"{{key}}", apiKeyParam,
+).Replace(widgetJS)
+
+func serveWidget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(widgetScript))
+}
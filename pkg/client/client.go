@@ -0,0 +1,266 @@
+// Package client is the Go client of the captcha service. It wraps the
+// generated gRPC stubs with per-call deadlines, retries of unavailable
+// replicas, optional TLS, and round-robin load balancing across the addresses
+// a target resolves to. Only Get is retried: a Validate that reached the
+// service consumed the challenge, even if its answer was lost.
+//
+//	c, err := client.New("dns:///captcha.internal:8666", client.Config{})
+//	ch, err := c.Issue(ctx, userID)
+//	...
+//	switch err := c.Check(ctx, id, code); err {
+//	case nil:
+//		// solved
+//	case client.ErrWrongSolution:
+//		// ask again
+//	}
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+var (
+	// ErrWrongSolution is returned by Check if the solution is wrong, or the
+	// captcha doesn't exist (anymore).
+	ErrWrongSolution = errors.New("captcha: wrong solution")
+	// ErrRateLimited is returned when the service rejects the call because of
+	// its rate limit.
+	ErrRateLimited = errors.New("captcha: rate limited")
+)
+
+// StatusError is returned by Check for status codes it doesn't know.
+type StatusError struct {
+	Code    int32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("captcha: unexpected status %d: %s", e.Code, e.Message)
+}
+
+// Default client settings.
+const (
+	DefaultTimeout    = 5 * time.Second
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 100 * time.Millisecond
+)
+
+// roundRobin balances calls across all addresses the target resolves to.
+const roundRobin = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// Config configures a Client. The zero value is a plaintext client with the
+// default timeout and retries.
+type Config struct {
+	Timeout    time.Duration // per attempt, default DefaultTimeout
+	MaxRetries int           // retries of Unavailable Get calls, default DefaultMaxRetries; negative disables retries
+	Backoff    time.Duration // before the first retry, doubled for every further one, default DefaultBackoff
+	// TLS enables transport security; see LoadTLSConfig. Plaintext if nil.
+	TLS *tls.Config
+	// APIKey is sent with every call to a service with tenants. It requires
+	// TLS, unless Insecure is set.
+	APIKey string
+	// Insecure allows sending APIKey over a plaintext connection, for tests
+	// or a TLS-terminating proxy on a trusted network.
+	Insecure bool
+	// DialOptions are passed to grpc.Dial after the ones set by the client.
+	DialOptions []grpc.DialOption
+}
+
+// Client is a client of the captcha service. It's safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	pb   pb.CaptchaClient
+}
+
+// New returns a client of the captcha service at target, in gRPC name syntax.
+// Use a "dns:///" target to balance calls across all replicas behind a name.
+// Calls carry the OpenTelemetry trace of their context to the service.
+func New(target string, cfg Config) (*Client, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultBackoff
+	}
+
+	if cfg.APIKey != "" && cfg.TLS == nil && !cfg.Insecure {
+		return nil, errors.New("captcha: an API key requires TLS, or Config.Insecure")
+	}
+
+	creds := grpc.WithInsecure()
+	if cfg.TLS != nil {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(cfg.TLS))
+	}
+	opts := []grpc.DialOption{
+		creds,
+		grpc.WithDefaultServiceConfig(roundRobin),
+		grpc.WithChainUnaryInterceptor(
+			otelgrpc.UnaryClientInterceptor(),
+			retryInterceptor(cfg),
+		),
+	}
+	if cfg.APIKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKey{cfg.APIKey, cfg.Insecure}))
+	}
+	opts = append(opts, cfg.DialOptions...)
+
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, pb: pb.NewCaptchaClient(conn)}, nil
+}
+
+// Close closes the connections of the client.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Captcha returns the underlying gRPC client, with the deadlines and retries
+// of the client, for calls without a helper.
+func (c *Client) Captcha() pb.CaptchaClient {
+	return c.pb
+}
+
+// Issue returns a new image challenge for the user.
+func (c *Client) Issue(ctx context.Context, userID string) (*pb.Challenge, error) {
+	ch, err := c.pb.Get(ctx, &pb.User{Id: userID})
+	return ch, mapError(err)
+}
+
+// IssuePoW returns a new proof-of-work challenge for the user.
+func (c *Client) IssuePoW(ctx context.Context, userID string) (*pb.Challenge, error) {
+	ch, err := c.pb.Get(ctx, &pb.User{Id: userID, Type: pb.ChallengeType_PROOF_OF_WORK})
+	return ch, mapError(err)
+}
+
+// Check validates the solution of the image challenge with the given id. It
+// returns nil if the solution is right, and ErrWrongSolution if it isn't. A
+// challenge can only be checked once.
+func (c *Client) Check(ctx context.Context, id, code string) error {
+	return c.check(ctx, &pb.Solution{Id: id, Code: code})
+}
+
+// CheckPoW is like Check, but validates the nonce of a proof-of-work
+// challenge.
+func (c *Client) CheckPoW(ctx context.Context, id, nonce string) error {
+	return c.check(ctx, &pb.Solution{Id: id, Code: nonce, Type: pb.ChallengeType_PROOF_OF_WORK})
+}
+
+func (c *Client) check(ctx context.Context, sol *pb.Solution) error {
+	st, err := c.pb.Validate(ctx, sol)
+	if err != nil {
+		return mapError(err)
+	}
+	switch st.Code {
+	case 200:
+		return nil
+	case 400:
+		return ErrWrongSolution
+	default:
+		return &StatusError{Code: st.Code, Message: st.Message}
+	}
+}
+
+// mapError maps gRPC errors with a meaning to the client to its errors.
+func mapError(err error) error {
+	if status.Code(err) == codes.ResourceExhausted {
+		return ErrRateLimited
+	}
+	return err
+}
+
+// apiKey sends the API key of a tenant in the metadata of every call, as
+// captcha.APIKeyHeader.
+type apiKey struct {
+	key      string
+	insecure bool
+}
+
+func (k apiKey) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": k.key}, nil
+}
+
+// RequireTransportSecurity returns true unless Config.Insecure is set, so that
+// keys aren't sent in cleartext by mistake.
+func (k apiKey) RequireTransportSecurity() bool {
+	return !k.insecure
+}
+
+// idempotent lists the methods that are retried. Validate isn't: the attempt
+// that failed may have consumed the challenge, and a retry would report a
+// right solution as wrong.
+var idempotent = map[string]bool{
+	"/api.Captcha/Get": true,
+}
+
+// retryInterceptor gives every attempt of a call its own deadline, and retries
+// idempotent calls failing with Unavailable with exponential backoff and
+// jitter, as long as the context of the call allows.
+func retryInterceptor(cfg Config) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		maxRetries := cfg.MaxRetries
+		if !idempotent[method] {
+			maxRetries = 0
+		}
+		backoff := cfg.Backoff
+		for attempt := 0; ; attempt++ {
+			actx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+			err := invoker(actx, method, req, reply, cc, opts...)
+			cancel()
+			if status.Code(err) != codes.Unavailable || attempt >= maxRetries {
+				return err
+			}
+
+			sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+			select {
+			case <-time.After(sleep):
+			case <-ctx.Done():
+				return err
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// LoadTLSConfig returns a TLS configuration verifying the server against the
+// CA certificates in caFile (the system roots if empty). If certFile and
+// keyFile are set, the client presents that certificate for mutual TLS.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("captcha: no certificates in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
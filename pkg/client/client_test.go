@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/roachapp/captcha/api"
+)
+
+// fakeServer fails the first `failures` calls with Unavailable, and accepts
+// the solution "42".
+type fakeServer struct {
+	pb.UnimplementedCaptchaServer
+	failures int32
+	calls    int32
+	limited  bool
+}
+
+func (s *fakeServer) Get(ctx context.Context, u *pb.User) (*pb.Challenge, error) {
+	if atomic.AddInt32(&s.calls, 1) <= s.failures {
+		return nil, status.Error(codes.Unavailable, "try another replica")
+	}
+	if s.limited {
+		return nil, status.Error(codes.ResourceExhausted, "slow down")
+	}
+	id := "id-" + u.Id
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("x-api-key")) > 0 {
+		id += "@" + md.Get("x-api-key")[0]
+	}
+	return &pb.Challenge{Id: id, Type: u.Type}, nil
+}
+
+func (s *fakeServer) Validate(ctx context.Context, sol *pb.Solution) (*pb.Status, error) {
+	if atomic.AddInt32(&s.calls, 1) <= s.failures {
+		return nil, status.Error(codes.Unavailable, "try another replica")
+	}
+	switch sol.Code {
+	case "42":
+		return &pb.Status{Code: 200}, nil
+	case "teapot":
+		return &pb.Status{Code: 418, Message: "I'm a teapot"}, nil
+	}
+	return &pb.Status{Code: 400}, nil
+}
+
+func newTestClient(t *testing.T, s *fakeServer, cfg Config) *Client {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterCaptchaServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cfg.DialOptions = append(cfg.DialOptions,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	c, err := New("passthrough:///bufnet", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestIssueRetries(t *testing.T) {
+	s := &fakeServer{failures: 2}
+	c := newTestClient(t, s, Config{Backoff: time.Millisecond})
+	ch, err := c.Issue(context.Background(), "alice")
+	if err != nil || ch.Id != "id-alice" {
+		t.Fatalf("Issue: %v %v", ch, err)
+	}
+	if n := atomic.LoadInt32(&s.calls); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	s = &fakeServer{failures: 5}
+	c = newTestClient(t, s, Config{Backoff: time.Millisecond, MaxRetries: 2})
+	if _, err := c.Issue(context.Background(), "bob"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable after retries, got %v", err)
+	}
+	if n := atomic.LoadInt32(&s.calls); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	s = &fakeServer{failures: 5}
+	c = newTestClient(t, s, Config{MaxRetries: -1})
+	if _, err := c.IssuePoW(context.Background(), "carol"); status.Code(err) != codes.Unavailable || atomic.LoadInt32(&s.calls) != 1 {
+		t.Errorf("expected a single failed attempt, got %d: %v", s.calls, err)
+	}
+
+	// Validate isn't retried, since the failed attempt may have consumed the
+	// challenge.
+	s = &fakeServer{failures: 1}
+	c = newTestClient(t, s, Config{Backoff: time.Millisecond})
+	if err := c.Check(context.Background(), "id", "42"); status.Code(err) != codes.Unavailable || atomic.LoadInt32(&s.calls) != 1 {
+		t.Errorf("expected a single failed attempt, got %d: %v", s.calls, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestClient(t, &fakeServer{limited: true}, Config{})
+	ctx := context.Background()
+	if _, err := c.Issue(ctx, "dave"); err != ErrRateLimited {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if err := c.Check(ctx, "id", "42"); err != nil {
+		t.Errorf("right solution: %v", err)
+	}
+	if err := c.Check(ctx, "id", "41"); err != ErrWrongSolution {
+		t.Errorf("expected ErrWrongSolution, got %v", err)
+	}
+	if err, ok := c.CheckPoW(ctx, "id", "teapot").(*StatusError); !ok || err.Code != 418 {
+		t.Errorf("expected StatusError, got %v", err)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	c := newTestClient(t, &fakeServer{}, Config{APIKey: "shop-key", Insecure: true})
+	ch, err := c.Issue(context.Background(), "erin")
+	if err != nil || ch.Id != "id-erin@shop-key" {
+		t.Errorf("expected the API key in the metadata, got %v %v", ch, err)
+	}
+
+	if _, err := New("passthrough:///bufnet", Config{APIKey: "shop-key"}); err == nil {
+		t.Errorf("API key allowed without TLS")
+	}
+}
@@ -0,0 +1,539 @@
+// Package config loads the configuration of the captcha server.
+//
+// Settings are taken, in increasing order of precedence, from the defaults,
+// a YAML file (given with -config or CAPTCHA_CONFIG), environment variables
+// and command-line flags. Run the server with -help for the list of flags
+// and the environment variables they correspond to.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store backends.
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+	BackendBolt     = "bolt"
+)
+
+// Memory tiers.
+const (
+	CacheCollect = "collect"
+	CacheLRU     = "lru"
+)
+
+// Config is the configuration of the captcha server.
+type Config struct {
+	GRPCAddr    string          `yaml:"grpc_addr"`
+	HTTPAddr    string          `yaml:"http_addr"`    // empty disables the HTTP server
+	MetricsAddr string          `yaml:"metrics_addr"` // empty disables /metrics
+	LogLevel    string          `yaml:"log_level"`
+	Reflection  bool            `yaml:"reflection"` // registers the gRPC reflection service, for grpcurl
+	TLS         TLSConfig       `yaml:"tls"`
+	Store       StoreConfig     `yaml:"store"`
+	Captcha     CaptchaConfig   `yaml:"captcha"`
+	RateLimit   RateLimitConfig `yaml:"rate_limit"`
+	Tracing     TracingConfig   `yaml:"tracing"`
+	Audit       AuditConfig     `yaml:"audit"`
+	Admin       AdminConfig     `yaml:"admin"`
+	// Tenants, if any, are only set in the configuration file. When there
+	// are tenants, every request needs one of their API keys.
+	Tenants []TenantConfig `yaml:"tenants"`
+}
+
+// TLSConfig configures transport security of the gRPC and HTTP servers. It's
+// off unless both CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Enabled returns true if TLS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// StoreConfig configures the stores of captchas.
+type StoreConfig struct {
+	// Backend of the persistent tier, BackendMemory (none),
+	// BackendPostgres or BackendBolt. The memory tier is always used.
+	Backend     string        `yaml:"backend"`
+	DatabaseURL string        `yaml:"database_url"`
+	CollectNum  int           `yaml:"collect_num"` // collect cache only
+	TTL         time.Duration `yaml:"ttl"`
+	// BoltFile is the file of the bolt backend. The expired captchas of the
+	// bolt and postgres backends are removed every SweepInterval.
+	BoltFile      string        `yaml:"bolt_file"`
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+	// Cache is the memory tier: CacheLRU holds at most Capacity captchas,
+	// and removes the expired ones every JanitorInterval if it's set;
+	// CacheCollect is unbounded, and collects expired captchas every
+	// CollectNum stored ones. The LRU cache is split into Shards, each
+	// holding its part of Capacity, to spread the lock contention.
+	Cache           string        `yaml:"cache"`
+	Capacity        int           `yaml:"capacity"`
+	JanitorInterval time.Duration `yaml:"janitor_interval"`
+	Shards          int           `yaml:"shards"`
+	// SnapshotFile, if set, keeps the memory tier across restarts,
+	// encrypted with SnapshotKey. It's only allowed with BackendMemory.
+	SnapshotFile string `yaml:"snapshot_file"`
+	SnapshotKey  string `yaml:"snapshot_key"`
+	// AnonymousTTL, if set, caps the TTL of challenges issued without a user
+	// id, and PoWTTL replaces TTL for proof-of-work challenges.
+	AnonymousTTL time.Duration `yaml:"anonymous_ttl"`
+	PoWTTL       time.Duration `yaml:"pow_ttl"`
+	// Pool configures the connections to DatabaseURL.
+	Pool PoolConfig `yaml:"pool"`
+}
+
+// PoolConfig configures the connection pools of the postgres backend and of
+// the postgres audit sink, each opening its own. Startup fails after
+// ConnectAttempts failed attempts to connect, retried with an exponential
+// backoff starting at ConnectBackoff.
+type PoolConfig struct {
+	MaxConns          int           `yaml:"max_conns"`
+	MinConns          int           `yaml:"min_conns"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	StatementCache    int           `yaml:"statement_cache"` // per connection, -1 disables it
+	ConnectAttempts   int           `yaml:"connect_attempts"`
+	ConnectBackoff    time.Duration `yaml:"connect_backoff"`
+}
+
+// CaptchaConfig configures the generated captchas.
+type CaptchaConfig struct {
+	DigitLen      int    `yaml:"digit_len"`
+	Width         int    `yaml:"width"`
+	Height        int    `yaml:"height"`
+	PoWDifficulty int    `yaml:"pow_difficulty"`
+	Secret        string `yaml:"secret"`
+	RNGKeys       string `yaml:"rng_keys"`
+	RNGKeysFile   string `yaml:"rng_keys_file"`
+	PoolSize      int    `yaml:"pool_size"` // 0 disables pre-rendering
+	PoolWorkers   int    `yaml:"pool_workers"`
+	// Color of the digits in the images, "#rrggbb"; see ParseColor.
+	Color string `yaml:"color"`
+}
+
+// RateLimitConfig configures the rate limit shared by the servers: a request
+// every Every, with bursts of up to Burst requests.
+type RateLimitConfig struct {
+	Every time.Duration `yaml:"every"`
+	Burst int           `yaml:"burst"`
+}
+
+// TracingConfig configures the export of OpenTelemetry spans.
+type TracingConfig struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"` // OTLP gRPC collector, host:port
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// AuditConfig configures the audit trail of challenges. It's off unless File
+// or Postgres is set. Events are queued, up to Buffer of them, and written in
+// the background; the ones that don't fit are dropped.
+type AuditConfig struct {
+	File     string `yaml:"file"`     // JSON lines
+	Postgres bool   `yaml:"postgres"` // captcha_audit table of store.database_url
+	Buffer   int    `yaml:"buffer"`
+}
+
+// AdminConfig configures the CaptchaAdmin gRPC service. Its calls need Key,
+// or a client certificate verified with tls.client_ca_file whose subject
+// common name is one of ClientNames, which are only set in the configuration
+// file. Other certificates of the CA, like the backends', are refused.
+type AdminConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Key         string   `yaml:"key"`
+	ClientNames []string `yaml:"client_names"`
+}
+
+// TenantConfig configures a tenant of the service. Zero settings are taken
+// from the captcha, store and rate_limit sections; the tenant gets its own
+// rate limit either way. Color themes the tenant's images, like
+// captcha.color.
+type TenantConfig struct {
+	Name          string          `yaml:"name"`
+	APIKeys       []string        `yaml:"api_keys"`
+	DigitLen      int             `yaml:"digit_len"`
+	Width         int             `yaml:"width"`
+	Height        int             `yaml:"height"`
+	PoWDifficulty int             `yaml:"pow_difficulty"`
+	PoolSize      int             `yaml:"pool_size"`
+	RateLimit     RateLimitConfig `yaml:"rate_limit"`
+	TTL           time.Duration   `yaml:"ttl"`
+	AnonymousTTL  time.Duration   `yaml:"anonymous_ttl"`
+	PoWTTL        time.Duration   `yaml:"pow_ttl"`
+	Color         string          `yaml:"color"`
+}
+
+// inheritTenantSettings fills the zero settings of the tenants from the
+// captcha, store and rate_limit sections.
+func (c *Config) inheritTenantSettings() {
+	inherit := func(v *int, def int) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	inheritDuration := func(v *time.Duration, def time.Duration) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	for i := range c.Tenants {
+		t := &c.Tenants[i]
+		inherit(&t.DigitLen, c.Captcha.DigitLen)
+		inherit(&t.Width, c.Captcha.Width)
+		inherit(&t.Height, c.Captcha.Height)
+		inherit(&t.PoWDifficulty, c.Captcha.PoWDifficulty)
+		inherit(&t.PoolSize, c.Captcha.PoolSize)
+		if t.Color == "" {
+			t.Color = c.Captcha.Color
+		}
+		inherit(&t.RateLimit.Burst, c.RateLimit.Burst)
+		if t.RateLimit.Every == 0 {
+			t.RateLimit.Every = c.RateLimit.Every
+		}
+		inheritDuration(&t.TTL, c.Store.TTL)
+		inheritDuration(&t.AnonymousTTL, c.Store.AnonymousTTL)
+		inheritDuration(&t.PoWTTL, c.Store.PoWTTL)
+	}
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		GRPCAddr:    "0.0.0.0:8666",
+		HTTPAddr:    "0.0.0.0:8667",
+		MetricsAddr: "0.0.0.0:8668",
+		LogLevel:    "info",
+		Store: StoreConfig{
+			Backend:         BackendPostgres,
+			CollectNum:      100,
+			TTL:             30 * time.Second,
+			SweepInterval:   time.Minute,
+			Cache:           CacheLRU,
+			Capacity:        100000,
+			JanitorInterval: 10 * time.Second,
+			Shards:          16,
+			Pool: PoolConfig{
+				MaxConns:          10,
+				MaxConnLifetime:   time.Hour,
+				MaxConnIdleTime:   30 * time.Minute,
+				HealthCheckPeriod: time.Minute,
+				StatementCache:    512,
+				ConnectAttempts:   10,
+				ConnectBackoff:    500 * time.Millisecond,
+			},
+		},
+		Captcha: CaptchaConfig{
+			DigitLen:      3,
+			Width:         160,
+			Height:        80,
+			PoWDifficulty: 16,
+			PoolSize:      64,
+			PoolWorkers:   4,
+		},
+		RateLimit: RateLimitConfig{
+			Every: 30 * time.Second,
+			Burst: 3,
+		},
+		Audit: AuditConfig{
+			Buffer: 4096,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// setting binds a field of Config to a flag and an environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"grpc-addr", "CAPTCHA_GRPC_ADDR", "listen address of the gRPC server", func(c *Config) interface{} { return &c.GRPCAddr }},
+	{"http-addr", "CAPTCHA_HTTP_ADDR", "listen address of the HTTP server, empty to disable it", func(c *Config) interface{} { return &c.HTTPAddr }},
+	{"metrics-addr", "CAPTCHA_METRICS_ADDR", "listen address of the Prometheus /metrics endpoint, empty to disable it", func(c *Config) interface{} { return &c.MetricsAddr }},
+	{"log-level", "CAPTCHA_LOG_LEVEL", "log level (reloadable)", func(c *Config) interface{} { return &c.LogLevel }},
+	{"reflection", "CAPTCHA_REFLECTION", "enable gRPC reflection", func(c *Config) interface{} { return &c.Reflection }},
+	{"tls-cert", "CAPTCHA_TLS_CERT", "TLS certificate file of the gRPC server", func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls-key", "CAPTCHA_TLS_KEY", "TLS key file of the gRPC server", func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls-client-ca", "CAPTCHA_TLS_CLIENT_CA", "CA certificates file to verify client certificates", func(c *Config) interface{} { return &c.TLS.ClientCAFile }},
+	{"store", "CAPTCHA_STORE", "persistent store backend: memory, postgres or bolt", func(c *Config) interface{} { return &c.Store.Backend }},
+	{"database-url", "DATABASE_URL", "postgres connection string", func(c *Config) interface{} { return &c.Store.DatabaseURL }},
+	{"bolt-file", "CAPTCHA_BOLT_FILE", "file of the bolt store backend", func(c *Config) interface{} { return &c.Store.BoltFile }},
+	{"sweep-interval", "CAPTCHA_SWEEP_INTERVAL", "interval of removals of expired captchas from the bolt and postgres stores, 0 to disable", func(c *Config) interface{} { return &c.Store.SweepInterval }},
+	{"pg-max-conns", "CAPTCHA_PG_MAX_CONNS", "maximum number of postgres connections", func(c *Config) interface{} { return &c.Store.Pool.MaxConns }},
+	{"pg-min-conns", "CAPTCHA_PG_MIN_CONNS", "number of postgres connections kept open", func(c *Config) interface{} { return &c.Store.Pool.MinConns }},
+	{"pg-max-conn-lifetime", "CAPTCHA_PG_MAX_CONN_LIFETIME", "duration after which postgres connections are closed", func(c *Config) interface{} { return &c.Store.Pool.MaxConnLifetime }},
+	{"pg-max-conn-idle-time", "CAPTCHA_PG_MAX_CONN_IDLE_TIME", "duration after which idle postgres connections are closed", func(c *Config) interface{} { return &c.Store.Pool.MaxConnIdleTime }},
+	{"pg-health-check-period", "CAPTCHA_PG_HEALTH_CHECK_PERIOD", "interval of health checks of idle postgres connections", func(c *Config) interface{} { return &c.Store.Pool.HealthCheckPeriod }},
+	{"pg-statement-cache", "CAPTCHA_PG_STATEMENT_CACHE", "prepared statements cached per postgres connection, -1 to disable", func(c *Config) interface{} { return &c.Store.Pool.StatementCache }},
+	{"pg-connect-attempts", "CAPTCHA_PG_CONNECT_ATTEMPTS", "attempts to connect to postgres on startup", func(c *Config) interface{} { return &c.Store.Pool.ConnectAttempts }},
+	{"pg-connect-backoff", "CAPTCHA_PG_CONNECT_BACKOFF", "wait before the first retry to connect to postgres, doubled on every retry", func(c *Config) interface{} { return &c.Store.Pool.ConnectBackoff }},
+	{"collect-num", "CAPTCHA_COLLECT_NUM", "number of captchas stored in memory between collections", func(c *Config) interface{} { return &c.Store.CollectNum }},
+	{"cache", "CAPTCHA_CACHE", "memory store: lru or collect", func(c *Config) interface{} { return &c.Store.Cache }},
+	{"cache-capacity", "CAPTCHA_CACHE_CAPACITY", "maximum number of captchas in the lru memory store", func(c *Config) interface{} { return &c.Store.Capacity }},
+	{"cache-janitor-interval", "CAPTCHA_CACHE_JANITOR_INTERVAL", "interval of removals of expired captchas from the lru memory store, 0 to disable", func(c *Config) interface{} { return &c.Store.JanitorInterval }},
+	{"cache-shards", "CAPTCHA_CACHE_SHARDS", "number of independently locked shards of the lru memory store", func(c *Config) interface{} { return &c.Store.Shards }},
+	{"snapshot-file", "CAPTCHA_SNAPSHOT_FILE", "file the memory store is saved to on shutdown and restored from on startup", func(c *Config) interface{} { return &c.Store.SnapshotFile }},
+	{"snapshot-key", "CAPTCHA_SNAPSHOT_KEY", "key encrypting the snapshot file", func(c *Config) interface{} { return &c.Store.SnapshotKey }},
+	{"ttl", "CAPTCHA_TTL", "expiration time of captchas", func(c *Config) interface{} { return &c.Store.TTL }},
+	{"anonymous-ttl", "CAPTCHA_ANONYMOUS_TTL", "maximum expiration time of captchas issued without a user id, 0 for ttl", func(c *Config) interface{} { return &c.Store.AnonymousTTL }},
+	{"pow-ttl", "CAPTCHA_POW_TTL", "expiration time of proof-of-work challenges, 0 for ttl", func(c *Config) interface{} { return &c.Store.PoWTTL }},
+	{"digit-len", "CAPTCHA_DIGIT_LEN", "number of digits of captcha solutions", func(c *Config) interface{} { return &c.Captcha.DigitLen }},
+	{"width", "CAPTCHA_WIDTH", "width of captcha images", func(c *Config) interface{} { return &c.Captcha.Width }},
+	{"height", "CAPTCHA_HEIGHT", "height of captcha images", func(c *Config) interface{} { return &c.Captcha.Height }},
+	{"pow-difficulty", "CAPTCHA_POW_DIFFICULTY", "base difficulty of proof-of-work challenges, in bits", func(c *Config) interface{} { return &c.Captcha.PoWDifficulty }},
+	{"secret", "CAPTCHA_SECRET", "secret used to hash and seal solutions", func(c *Config) interface{} { return &c.Captcha.Secret }},
+	{"rng-keys", "CAPTCHA_RNG_KEYS", "image rendering keys, \"id:hex\" separated by commas, current first", func(c *Config) interface{} { return &c.Captcha.RNGKeys }},
+	{"rng-keys-file", "CAPTCHA_RNG_KEYS_FILE", "file with image rendering keys, one per line, current first", func(c *Config) interface{} { return &c.Captcha.RNGKeysFile }},
+	{"pool-size", "CAPTCHA_POOL_SIZE", "number of pre-rendered captchas, 0 to disable", func(c *Config) interface{} { return &c.Captcha.PoolSize }},
+	{"pool-workers", "CAPTCHA_POOL_WORKERS", "number of workers pre-rendering captchas", func(c *Config) interface{} { return &c.Captcha.PoolWorkers }},
+	{"color", "CAPTCHA_COLOR", "color of the digits in captcha images, \"#rrggbb\", random if empty", func(c *Config) interface{} { return &c.Captcha.Color }},
+	{"rate-every", "CAPTCHA_RATE_EVERY", "allow a request every this duration (reloadable)", func(c *Config) interface{} { return &c.RateLimit.Every }},
+	{"rate-burst", "CAPTCHA_RATE_BURST", "maximum burst of requests (reloadable)", func(c *Config) interface{} { return &c.RateLimit.Burst }},
+	{"audit-file", "CAPTCHA_AUDIT_FILE", "append the audit trail to this file as JSON lines", func(c *Config) interface{} { return &c.Audit.File }},
+	{"audit-postgres", "CAPTCHA_AUDIT_POSTGRES", "insert the audit trail in the captcha_audit table", func(c *Config) interface{} { return &c.Audit.Postgres }},
+	{"audit-buffer", "CAPTCHA_AUDIT_BUFFER", "number of audit events queued for writing, beyond which they are dropped", func(c *Config) interface{} { return &c.Audit.Buffer }},
+	{"admin", "CAPTCHA_ADMIN", "serve the CaptchaAdmin gRPC service", func(c *Config) interface{} { return &c.Admin.Enabled }},
+	{"admin-key", "CAPTCHA_ADMIN_KEY", "key of the CaptchaAdmin service, in the x-admin-key metadata", func(c *Config) interface{} { return &c.Admin.Key }},
+	{"trace-exporter", "CAPTCHA_TRACE_EXPORTER", "exporter of traces: none, stdout or otlp", func(c *Config) interface{} { return &c.Tracing.Exporter }},
+	{"trace-endpoint", "CAPTCHA_TRACE_ENDPOINT", "OTLP gRPC collector address, host:port", func(c *Config) interface{} { return &c.Tracing.Endpoint }},
+	{"trace-insecure", "CAPTCHA_TRACE_INSECURE", "connect to the OTLP collector without TLS", func(c *Config) interface{} { return &c.Tracing.Insecure }},
+	{"trace-sample-ratio", "CAPTCHA_TRACE_SAMPLE_RATIO", "fraction of traces sampled", func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+}
+
+// set parses s into the field pointed to by p.
+func set(p interface{}, s string) (err error) {
+	switch p := p.(type) {
+	case *string:
+		*p = s
+	case *bool:
+		*p, err = strconv.ParseBool(s)
+	case *int:
+		*p, err = strconv.Atoi(s)
+	case *float64:
+		*p, err = strconv.ParseFloat(s, 64)
+	case *time.Duration:
+		*p, err = time.ParseDuration(s)
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", p))
+	}
+	return err
+}
+
+// flagValue records the value of a flag, to be applied after the file and the
+// environment.
+type flagValue struct {
+	value string
+	set   bool
+}
+
+func (v *flagValue) String() string { return v.value }
+
+func (v *flagValue) Set(s string) error {
+	v.value, v.set = s, true
+	return nil
+}
+
+// boolFlagValue is a flagValue for boolean settings, which can be given
+// without a value, like -reflection.
+type boolFlagValue struct {
+	*flagValue
+}
+
+func (v boolFlagValue) IsBoolFlag() bool { return true }
+
+// Load returns the configuration from the defaults, the configuration file,
+// the environment and the given command-line arguments (without the program
+// name), and validates it.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("captcha", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CAPTCHA_CONFIG"), "YAML configuration file (CAPTCHA_CONFIG)")
+	flags := make([]flagValue, len(settings))
+	for i, s := range settings {
+		var v flag.Value = &flags[i]
+		if _, ok := s.field(new(Config)).(*bool); ok {
+			v = boolFlagValue{&flags[i]}
+		}
+		fs.Var(v, s.flag, fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if *configFile != "" {
+		b, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, fmt.Errorf("config: %s: %v", *configFile, err)
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := set(s.field(c), v); err != nil {
+				return nil, fmt.Errorf("config: %s: %v", s.env, err)
+			}
+		}
+	}
+	for i, s := range settings {
+		if flags[i].set {
+			if err := set(s.field(c), flags[i].value); err != nil {
+				return nil, fmt.Errorf("config: -%s: %v", s.flag, err)
+			}
+		}
+	}
+
+	c.inheritTenantSettings()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseColor parses a color in the "#rrggbb" form, as in captcha.color. It
+// returns nil for the empty string.
+func ParseColor(s string) (color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("%q isn't in the #rrggbb form", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%q isn't in the #rrggbb form", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.GRPCAddr != "", "grpc_addr is required")
+	_, err := log.ParseLevel(c.LogLevel)
+	check(err == nil, "unknown log_level %q", c.LogLevel)
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls.key_file is required with tls.cert_file")
+	check(c.TLS.KeyFile == "" || c.TLS.CertFile != "", "tls.cert_file is required with tls.key_file")
+	check(c.TLS.ClientCAFile == "" || c.TLS.Enabled(), "tls.client_ca_file requires tls.cert_file and tls.key_file")
+
+	switch c.Store.Backend {
+	case BackendMemory:
+	case BackendPostgres:
+		check(c.Store.DatabaseURL != "", "store.database_url is required with the postgres backend")
+		check(c.Store.SweepInterval >= 0, "store.sweep_interval can't be negative")
+	case BackendBolt:
+		check(c.Store.BoltFile != "", "store.bolt_file is required with the bolt backend")
+		check(c.Store.SweepInterval >= 0, "store.sweep_interval can't be negative")
+	default:
+		check(false, "unknown store.backend %q", c.Store.Backend)
+	}
+	check(!c.Audit.Postgres || c.Store.DatabaseURL != "", "audit.postgres requires store.database_url")
+	check(c.Audit.Buffer > 0, "audit.buffer must be positive")
+	if c.Store.Backend == BackendPostgres || c.Audit.Postgres {
+		p := c.Store.Pool
+		check(p.MaxConns > 0, "store.pool.max_conns must be positive")
+		check(p.MinConns >= 0 && p.MinConns <= p.MaxConns, "store.pool.min_conns must be between 0 and store.pool.max_conns")
+		check(p.StatementCache >= -1, "store.pool.statement_cache must be -1 or more")
+		check(p.ConnectAttempts > 0, "store.pool.connect_attempts must be positive")
+		check(p.ConnectBackoff >= 0, "store.pool.connect_backoff can't be negative")
+	}
+	check(!c.Admin.Enabled || c.Admin.Key != "" || len(c.Admin.ClientNames) > 0, "admin requires admin.key or admin.client_names")
+	check(len(c.Admin.ClientNames) == 0 || c.TLS.ClientCAFile != "", "admin.client_names requires tls.client_ca_file")
+	switch c.Store.Cache {
+	case CacheLRU:
+		check(c.Store.Capacity > 0, "store.capacity must be positive")
+		check(c.Store.JanitorInterval >= 0, "store.janitor_interval can't be negative")
+		check(c.Store.Shards > 0 && c.Store.Shards <= c.Store.Capacity, "store.shards must be between 1 and store.capacity")
+	case CacheCollect:
+		check(c.Store.CollectNum > 0, "store.collect_num must be positive")
+	default:
+		check(false, "unknown store.cache %q", c.Store.Cache)
+	}
+	check(c.Store.TTL > 0, "store.ttl must be positive")
+	check(c.Store.SnapshotFile == "" || c.Store.SnapshotKey != "", "store.snapshot_file requires store.snapshot_key")
+	check(c.Store.SnapshotFile == "" || c.Store.Backend == BackendMemory, "store.snapshot_file requires the memory backend")
+	check(c.Store.AnonymousTTL >= 0 && c.Store.PoWTTL >= 0, "store.anonymous_ttl and store.pow_ttl can't be negative")
+
+	check(c.Captcha.DigitLen > 0 && c.Captcha.DigitLen <= 20, "captcha.digit_len must be between 1 and 20")
+	check(c.Captcha.Width >= 40 && c.Captcha.Height >= 20, "captcha image must be at least 40x20")
+	check(c.Captcha.PoWDifficulty > 0 && c.Captcha.PoWDifficulty <= 32, "captcha.pow_difficulty must be between 1 and 32")
+	check(c.Captcha.RNGKeys == "" || c.Captcha.RNGKeysFile == "", "captcha.rng_keys and captcha.rng_keys_file are exclusive")
+	check(c.Captcha.PoolSize >= 0, "captcha.pool_size can't be negative")
+	check(c.Captcha.PoolSize == 0 || c.Captcha.PoolWorkers > 0, "captcha.pool_workers must be positive")
+	_, err = ParseColor(c.Captcha.Color)
+	check(err == nil, "captcha.color: %v", err)
+
+	check(c.RateLimit.Every > 0, "rate_limit.every must be positive")
+	check(c.RateLimit.Burst > 0, "rate_limit.burst must be positive")
+
+	names, keys := make(map[string]bool), make(map[string]string)
+	for i, t := range c.Tenants {
+		check(t.Name != "", "tenants[%d].name is required", i)
+		check(!strings.Contains(t.Name, "/"), "tenant %q: name can't contain \"/\"", t.Name)
+		check(t.Name == "" || !names[t.Name], "duplicate tenant %q", t.Name)
+		names[t.Name] = true
+		check(len(t.APIKeys) > 0, "tenant %q needs api_keys", t.Name)
+		for _, k := range t.APIKeys {
+			other, dup := keys[k]
+			check(k != "", "tenant %q has an empty api key", t.Name)
+			check(!dup, "tenant %q reuses an api key of tenant %q", t.Name, other)
+			keys[k] = t.Name
+		}
+		check(t.DigitLen > 0 && t.DigitLen <= 20, "tenant %q: digit_len must be between 1 and 20", t.Name)
+		check(t.Width >= 40 && t.Height >= 20, "tenant %q: captcha image must be at least 40x20", t.Name)
+		check(t.PoWDifficulty > 0 && t.PoWDifficulty <= 32, "tenant %q: pow_difficulty must be between 1 and 32", t.Name)
+		check(t.PoolSize >= 0, "tenant %q: pool_size can't be negative", t.Name)
+		check(t.RateLimit.Every > 0 && t.RateLimit.Burst > 0, "tenant %q: rate_limit must be positive", t.Name)
+		check(t.TTL > 0 && t.AnonymousTTL >= 0 && t.PoWTTL >= 0, "tenant %q: ttl must be positive", t.Name)
+		_, err := ParseColor(t.Color)
+		check(err == nil, "tenant %q: color: %v", t.Name, err)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		check(false, "unknown tracing.exporter %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if len(errs) > 0 {
+		msg := "config: invalid configuration:"
+		for _, e := range errs {
+			msg += "\n\t" + e
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
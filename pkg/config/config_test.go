@@ -0,0 +1,216 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every variable read by Load for the duration of the test.
+func clearEnv(t *testing.T) {
+	names := []string{"CAPTCHA_CONFIG"}
+	for _, s := range settings {
+		names = append(names, s.env)
+	}
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			name, v := name, v
+			t.Cleanup(func() { os.Setenv(name, v) })
+		}
+	}
+}
+
+func setEnv(t *testing.T, name, value string) {
+	os.Setenv(name, value)
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func writeFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	name := filepath.Join(dir, "captcha.yaml")
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	if _, err := Load(nil); err == nil {
+		t.Errorf("postgres backend without a database url: expected error")
+	}
+
+	c, err := Load([]string{"-store", BackendMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Store.Backend = BackendMemory
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, expected %+v", c, want)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	file := writeFile(t, `
+grpc_addr: "file:1"
+http_addr: "file:2"
+log_level: debug
+store:
+  backend: memory
+rate_limit:
+  every: 10s
+  burst: 5
+`)
+	setEnv(t, "CAPTCHA_CONFIG", file)
+	setEnv(t, "CAPTCHA_HTTP_ADDR", "env:2")
+	setEnv(t, "CAPTCHA_RATE_BURST", "7")
+
+	c, err := Load([]string{"-rate-burst", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GRPCAddr != "file:1" {
+		t.Errorf("grpc addr %q: file not applied", c.GRPCAddr)
+	}
+	if c.HTTPAddr != "env:2" {
+		t.Errorf("http addr %q: environment doesn't override the file", c.HTTPAddr)
+	}
+	if c.RateLimit.Burst != 9 {
+		t.Errorf("rate burst %d: flag doesn't override the environment", c.RateLimit.Burst)
+	}
+	if c.RateLimit.Every != 10*time.Second || c.LogLevel != "debug" {
+		t.Errorf("got %+v, expected values from the file", c)
+	}
+	if c.Captcha.DigitLen != Default().Captcha.DigitLen {
+		t.Errorf("digit len %d: default not kept", c.Captcha.DigitLen)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	clearEnv(t)
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown key", "store:\n  backnd: memory\n", nil, "backnd"},
+		{"bad duration", "", []string{"-store", "memory", "-ttl", "soon"}, "-ttl"},
+		{"bad flag", "", []string{"-no-such-flag"}, "no-such-flag"},
+		{"backend", "", []string{"-store", "redis"}, "store.backend"},
+		{"tls", "", []string{"-store", "memory", "-tls-cert", "cert.pem"}, "tls.key_file"},
+		{"admin", "", []string{"-store", "memory", "-admin"}, "admin.key"},
+		{"admin clients", "admin:\n  client_names: [ops]\n", []string{"-store", "memory", "-admin"}, "tls.client_ca_file"},
+		{"cache", "", []string{"-store", "memory", "-cache", "redis"}, "store.cache"},
+		{"capacity", "", []string{"-store", "memory", "-cache-capacity", "0"}, "store.capacity"},
+		{"bolt", "", []string{"-store", "bolt"}, "store.bolt_file"},
+		{"pool", "", []string{"-database-url", "postgres://localhost/captcha", "-pg-min-conns", "20"}, "store.pool.min_conns"},
+		{"connect attempts", "store:\n  database_url: postgres://localhost/captcha\n  pool:\n    connect_attempts: 0\n", nil, "store.pool.connect_attempts"},
+		{"snapshot", "", []string{"-store", "memory", "-snapshot-file", "cache.snap"}, "store.snapshot_key"},
+		{"snapshot backend", "", []string{"-store", "bolt", "-bolt-file", "captcha.db", "-snapshot-file", "cache.snap", "-snapshot-key", "k"}, "memory backend"},
+		{"shards", "", []string{"-store", "memory", "-cache-capacity", "8", "-cache-shards", "16"}, "store.shards"},
+		{"color", "", []string{"-store", "memory", "-color", "red"}, "captcha.color"},
+		{"rng keys", "", []string{"-store", "memory", "-rng-keys", "a:00", "-rng-keys-file", "keys"}, "exclusive"},
+	}
+	for _, test := range tests {
+		args := test.args
+		if test.file != "" {
+			args = append([]string{"-config", writeFile(t, test.file)}, args...)
+		}
+		_, err := Load(args)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, expected it to mention %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	clearEnv(t)
+	file := writeFile(t, `
+store:
+  backend: memory
+  anonymous_ttl: 10s
+captcha:
+  digit_len: 5
+  color: "#203040"
+tenants:
+- name: shop
+  api_keys: [shop-1, shop-2]
+  width: 200
+  color: "#aa0000"
+  ttl: 2m
+  rate_limit:
+    burst: 10
+- name: forum
+  api_keys: [forum-1]
+`)
+	c, err := Load([]string{"-config", file, "-rate-every", "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Tenants) != 2 {
+		t.Fatalf("got %d tenants, expected 2", len(c.Tenants))
+	}
+	shop, forum := c.Tenants[0], c.Tenants[1]
+	if shop.Width != 200 || shop.DigitLen != 5 || shop.Height != Default().Captcha.Height {
+		t.Errorf("shop settings not inherited: %+v", shop)
+	}
+	if shop.RateLimit.Burst != 10 || shop.RateLimit.Every != 5*time.Second {
+		t.Errorf("shop rate limit not inherited: %+v", shop.RateLimit)
+	}
+	if shop.TTL != 2*time.Minute || shop.AnonymousTTL != 10*time.Second || forum.TTL != c.Store.TTL {
+		t.Errorf("TTLs not inherited: shop %v/%v, forum %v", shop.TTL, shop.AnonymousTTL, forum.TTL)
+	}
+	if shop.Color != "#aa0000" || forum.Color != "#203040" {
+		t.Errorf("colors not inherited: shop %q, forum %q", shop.Color, forum.Color)
+	}
+	if forum.RateLimit != c.RateLimit || forum.DigitLen != 5 {
+		t.Errorf("forum settings not inherited: %+v", forum)
+	}
+
+	file = writeFile(t, `
+store:
+  backend: memory
+tenants:
+- name: shop
+  api_keys: [key]
+- name: shop
+  api_keys: [key]
+- name: forum
+- name: shop/eu
+  api_keys: [eu]
+`)
+	_, err = Load([]string{"-config", file})
+	for _, want := range []string{`duplicate tenant "shop"`, "reuses an api key", `tenant "forum" needs api_keys`, `tenant "shop/eu": name can't contain "/"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v doesn't mention %s", err, want)
+		}
+	}
+}
+
+func TestValidateAggregates(t *testing.T) {
+	c := Default()
+	c.Store.Backend = BackendMemory
+	c.Captcha.DigitLen = 0
+	c.RateLimit.Burst = 0
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"digit_len", "rate_limit.burst"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+}
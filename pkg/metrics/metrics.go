@@ -0,0 +1,167 @@
+// Package metrics defines the Prometheus metrics of the captcha server and
+// the helpers that record them.
+//
+// The metrics are registered on Registry, together with the Go runtime and
+// process collectors, and served by Handler:
+//
+//   http.Handle("/metrics", metrics.Handler())
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "captcha"
+
+// Outcomes of validations.
+const (
+	OutcomeOK          = "ok"
+	OutcomeWrong       = "wrong"
+	OutcomeNotFound    = "not_found"
+	OutcomeExpired     = "expired"
+	OutcomeRateLimited = "rate_limited"
+)
+
+// Results of requests to a pool of pre-rendered captchas.
+const (
+	PoolHit  = "hit"
+	PoolMiss = "miss"
+)
+
+var (
+	// Registry holds the metrics of the captcha server.
+	Registry = prometheus.NewRegistry()
+
+	// ChallengesIssued counts issued challenges by type ("image" or
+	// "proof_of_work").
+	ChallengesIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "challenges_issued_total",
+		Help:      "Number of challenges issued, by type.",
+	}, []string{"type"})
+
+	// Validations counts validations by outcome, one of the Outcome
+	// constants. Challenges are expired until they are collected, and
+	// not_found afterwards.
+	Validations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validations_total",
+		Help:      "Number of validations, by outcome.",
+	}, []string{"outcome"})
+
+	// Reloads counts reloaded image captchas.
+	Reloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reloads_total",
+		Help:      "Number of reloaded captchas.",
+	})
+
+	// RenderSeconds observes the time to draw a captcha image, without
+	// encoding it.
+	RenderSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "render_seconds",
+		Help:      "Time to render a captcha image.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14),
+	})
+
+	// PNGBytes observes the size of encoded captcha images.
+	PNGBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "png_bytes",
+		Help:      "Size of PNG-encoded captcha images.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 8),
+	})
+
+	// StoreSeconds observes the latency of store operations by tier and
+	// operation ("set" or "get").
+	StoreSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_seconds",
+		Help:      "Latency of store operations, by tier and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"tier", "op"})
+
+	// StoreSize is the number of captchas held by stores that know it, by
+	// tier.
+	StoreSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "store_size",
+		Help:      "Number of captchas in the store, by tier.",
+	}, []string{"tier"})
+
+	// StoreEvictions counts the captchas removed from stores before they
+	// were consumed, by tier and reason ("expired" or "capacity").
+	StoreEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_evictions_total",
+		Help:      "Number of captchas evicted from the store, by tier and reason.",
+	}, []string{"tier", "reason"})
+
+	// PoolDepth is the number of pre-rendered captchas ready to be issued,
+	// by tenant (empty for the default generator).
+	PoolDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_depth",
+		Help:      "Number of pre-rendered captchas ready to be issued, by tenant.",
+	}, []string{"tenant"})
+
+	// PoolRequests counts the captchas issued from a pool ("hit") or
+	// rendered inline because it was empty ("miss"), by tenant. The hit rate
+	// is hit / (hit + miss).
+	PoolRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pool_requests_total",
+		Help:      "Number of captchas requested from the pre-rendering pool, by tenant and result.",
+	}, []string{"tenant", "result"})
+
+	// AuditEventsDropped counts the audit events dropped because the queue
+	// of the audit sink was full.
+	AuditEventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_events_dropped_total",
+		Help:      "Number of audit events dropped because the audit queue was full.",
+	})
+
+	// GRPCRequests counts gRPC requests by method and status code.
+	GRPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Number of gRPC requests, by method and status code.",
+	}, []string{"method", "code"})
+
+	// GRPCSeconds observes the latency of gRPC requests by method.
+	GRPCSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_seconds",
+		Help:      "Latency of gRPC requests, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ChallengesIssued,
+		Validations,
+		Reloads,
+		RenderSeconds,
+		PNGBytes,
+		StoreSeconds,
+		StoreSize,
+		StoreEvictions,
+		PoolDepth,
+		PoolRequests,
+		AuditEventsDropped,
+		GRPCRequests,
+		GRPCSeconds,
+	)
+}
+
+// Handler returns the handler serving the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/roachapp/captcha/pkg/store"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstrumentStore(t *testing.T) {
+	s := InstrumentStore("test", store.NewCacheStore(100, time.Minute))
+	ctx := context.Background()
+	s.Set(ctx, "a", []byte{1}, time.Time{})
+	s.Set(ctx, "b", []byte{2}, time.Time{})
+	if n := testutil.ToFloat64(StoreSize.WithLabelValues("test")); n != 2 {
+		t.Errorf("size after Set: got %v, expected 2", n)
+	}
+	if d, _ := s.Get(ctx, "a", true); len(d) != 1 || d[0] != 1 {
+		t.Errorf("Get: got %v", d)
+	}
+	if n := testutil.ToFloat64(StoreSize.WithLabelValues("test")); n != 1 {
+		t.Errorf("size after Get: got %v, expected 1", n)
+	}
+	if _, ok := s.(store.Closer); !ok {
+		t.Errorf("Close not passed through")
+	}
+	if n, ok := store.Len(s); !ok || n != 1 {
+		t.Errorf("store.Len through the wrapper: got %d, %v", n, ok)
+	}
+	if !store.Collect(s) {
+		t.Errorf("store.Collect doesn't reach the wrapped store")
+	}
+}
+
+func TestInstrumentStoreEvictions(t *testing.T) {
+	s := InstrumentStore("lru", store.NewLRUStore(1, time.Minute))
+	ctx := context.Background()
+	s.Set(ctx, "a", []byte{1}, time.Time{})
+	s.Set(ctx, "b", []byte{2}, time.Time{})
+	s.Set(ctx, "c", []byte{3}, time.Now().Add(-time.Second))
+	s.Get(ctx, "c", false)
+	if n := testutil.ToFloat64(StoreEvictions.WithLabelValues("lru", store.EvictCapacity)); n != 2 {
+		t.Errorf("capacity evictions: got %v, expected 2", n)
+	}
+	if n := testutil.ToFloat64(StoreEvictions.WithLabelValues("lru", store.EvictExpired)); n != 1 {
+		t.Errorf("expired evictions: got %v, expected 1", n)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Reloads.Inc()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, _ := ioutil.ReadAll(w.Body)
+	for _, want := range []string{"captcha_reloads_total", "go_goroutines"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics don't include %s", want)
+		}
+	}
+}
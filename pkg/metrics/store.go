@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"github.com/roachapp/captcha/pkg/store"
+	"time"
+)
+
+// instrumentedStore records the latency and size of the store it wraps.
+type instrumentedStore struct {
+	store.Store
+	tier string
+}
+
+// InstrumentStore returns a store that records the latency of the operations
+// of s, its size if it implements store.Sizer and its evictions if it
+// implements store.Evicter, under the given tier label ("cache" or
+// "postgres"). It passes Ping and Close through to s.
+func InstrumentStore(tier string, s store.Store) store.Store {
+	if e, ok := s.(store.Evicter); ok {
+		e.OnEvict(func(reason string) {
+			StoreEvictions.WithLabelValues(tier, reason).Inc()
+		})
+	}
+	return &instrumentedStore{Store: s, tier: tier}
+}
+
+func (s *instrumentedStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	start := time.Now()
+	s.Store.Set(ctx, id, digits, expiresAt)
+	StoreSeconds.WithLabelValues(s.tier, "set").Observe(time.Since(start).Seconds())
+	s.updateSize()
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
+	start := time.Now()
+	digits, expiresAt = s.Store.Get(ctx, id, clear)
+	StoreSeconds.WithLabelValues(s.tier, "get").Observe(time.Since(start).Seconds())
+	if clear {
+		s.updateSize()
+	}
+	return digits, expiresAt
+}
+
+func (s *instrumentedStore) updateSize() {
+	if sz, ok := s.Store.(store.Sizer); ok {
+		StoreSize.WithLabelValues(s.tier).Set(float64(sz.Len()))
+	}
+}
+
+// Unwrap returns the instrumented store, so that store.Len and store.Collect
+// reach it.
+func (s *instrumentedStore) Unwrap() store.Store {
+	return s.Store
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) error {
+	return store.Ping(ctx, s.Store)
+}
+
+func (s *instrumentedStore) Close() error {
+	return store.Close(s.Store)
+}
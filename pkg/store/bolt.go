@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+// Buckets of the bolt store. Captchas are kept by id, with their expiration
+// time in front of their digits:
+//
+//   captchas/<id> = expiresAt (unix nanoseconds, 8 bytes) || digits
+//
+// and indexed by expiration time in a bucket per slot of boltSlotWidth:
+//
+//   expiry/<slot start (unix seconds, 8 bytes)>/<id> = ""
+//
+// Slots sort by time, so the sweeper removes the captchas of whole expired
+// slots, and stops at the first slot that isn't.
+var (
+	captchasBucket = []byte("captchas")
+	expiryBucket   = []byte("expiry")
+)
+
+// boltSlotWidth is the time span of the slots of the expiry index.
+var boltSlotWidth = 10 * time.Second
+
+// BoltOption configures the store created by NewBoltStore.
+type BoltOption func(*boltStore)
+
+// WithSweeper removes the expired captchas every interval in a background
+// goroutine, stopped by Close. Without it, expired captchas stay in the file
+// until they are consumed or collected with Collect.
+func WithSweeper(interval time.Duration) BoltOption {
+	return func(s *boltStore) {
+		s.sweepInterval = interval
+	}
+}
+
+// boltStore is a persistent store in an embedded bbolt file, for deployments
+// without a database server.
+type boltStore struct {
+	db         *bolt.DB
+	expiration time.Duration
+
+	sweepInterval time.Duration
+	stop          chan struct{}
+	stopped       sync.WaitGroup
+	closeOnce     sync.Once
+}
+
+// NewBoltStore opens or creates the bbolt file at path and returns a store
+// keeping captchas in it, which expire after the given duration unless they
+// are set with an expiration time. The file is locked while the store is
+// open, so it can't be shared between processes.
+func NewBoltStore(path string, expiration time.Duration, opts ...BoltOption) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(captchasBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiryBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &boltStore{db: db, expiration: expiration, stop: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.sweepInterval > 0 {
+		s.stopped.Add(1)
+		go s.sweeper()
+	}
+	return s, nil
+}
+
+// slotKey returns the key of the expiry slot of t.
+func slotKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.Truncate(boltSlotWidth).Unix()))
+	return k
+}
+
+// decodeRecord splits a record of the captchas bucket. The digits are only
+// valid during the transaction the record was read in.
+func decodeRecord(v []byte) (digits []byte, expiresAt time.Time) {
+	if len(v) < 8 {
+		return nil, time.Time{}
+	}
+	return v[8:], time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+}
+
+// unindex removes the id from the expiry slot of expiresAt, and the slot if
+// it's empty.
+func unindex(tx *bolt.Tx, id []byte, expiresAt time.Time) error {
+	expiry := tx.Bucket(expiryBucket)
+	slot := expiry.Bucket(slotKey(expiresAt))
+	if slot == nil {
+		return nil
+	}
+	if err := slot.Delete(id); err != nil {
+		return err
+	}
+	if k, _ := slot.Cursor().First(); k == nil {
+		return expiry.DeleteBucket(slotKey(expiresAt))
+	}
+	return nil
+}
+
+func (s *boltStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(s.expiration)
+	}
+	key := []byte(id)
+	rec := make([]byte, 8+len(digits))
+	binary.BigEndian.PutUint64(rec, uint64(expiresAt.UnixNano()))
+	copy(rec[8:], digits)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		captchas := tx.Bucket(captchasBucket)
+		// Reloaded captchas move to the slot of their new expiration.
+		if old := captchas.Get(key); old != nil {
+			_, oldExpiresAt := decodeRecord(old)
+			if err := unindex(tx, key, oldExpiresAt); err != nil {
+				return err
+			}
+		}
+		if err := captchas.Put(key, rec); err != nil {
+			return err
+		}
+		slot, err := tx.Bucket(expiryBucket).CreateBucketIfNotExists(slotKey(expiresAt))
+		if err != nil {
+			return err
+		}
+		return slot.Put(key, nil)
+	})
+	if err != nil {
+		log.Errorf("bolt store: failed to set captcha: %v", err)
+	}
+}
+
+// Get returns the digits of the captcha. Clearing it is done in the same
+// transaction as reading it, so a captcha is consumed at most once.
+func (s *boltStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
+	key := []byte(id)
+	read := func(tx *bolt.Tx) error {
+		v := tx.Bucket(captchasBucket).Get(key)
+		if v == nil {
+			return nil
+		}
+		d, exp := decodeRecord(v)
+		expiresAt = exp
+		if time.Now().Before(exp) {
+			digits = append([]byte(nil), d...)
+		}
+		if !clear {
+			return nil
+		}
+		if err := tx.Bucket(captchasBucket).Delete(key); err != nil {
+			return err
+		}
+		return unindex(tx, key, exp)
+	}
+	var err error
+	if clear {
+		err = s.db.Update(read)
+	} else {
+		err = s.db.View(read)
+	}
+	if err != nil {
+		log.Errorf("bolt store: failed to get captcha: %v", err)
+		return nil, time.Time{}
+	}
+	return digits, expiresAt
+}
+
+// Collect removes the captchas of the expired slots of the expiry index. The
+// captchas of the current slot that already expired wait for a later
+// collection; Get never returns them meanwhile.
+func (s *boltStore) Collect() {
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		captchas, expiry := tx.Bucket(captchasBucket), tx.Bucket(expiryBucket)
+		var expired [][]byte
+		c := expiry.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			end := time.Unix(int64(binary.BigEndian.Uint64(k)), 0).Add(boltSlotWidth)
+			if end.After(now) {
+				break
+			}
+			err := expiry.Bucket(k).ForEach(func(id, _ []byte) error {
+				return captchas.Delete(id)
+			})
+			if err != nil {
+				return err
+			}
+			expired = append(expired, k)
+		}
+		// Buckets can't be deleted while iterating over their parent.
+		for _, k := range expired {
+			if err := expiry.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("bolt store: failed to collect expired captchas: %v", err)
+	}
+}
+
+// Len returns the number of captchas in the store, including expired ones
+// that are not collected yet.
+func (s *boltStore) Len() int {
+	n := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(captchasBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (s *boltStore) sweeper() {
+	defer s.stopped.Done()
+	t := time.NewTicker(s.sweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Collect()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the sweeper and closes the file.
+func (s *boltStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.stopped.Wait()
+		err = s.db.Close()
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openBolt(t *testing.T, path string, opts ...BoltOption) Store {
+	s, err := NewBoltStore(path, time.Minute, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func boltPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "captchas.db")
+}
+
+func TestBoltStore(t *testing.T) {
+	path := boltPath(t)
+	s := openBolt(t, path)
+	ctx := context.Background()
+
+	later := time.Now().Add(time.Hour).Round(0)
+	s.Set(ctx, "a", []byte{1, 2, 3}, later)
+	s.Set(ctx, "b", []byte{4}, time.Time{})
+	past := time.Now().Add(-time.Second).Round(0)
+	s.Set(ctx, "expired", []byte{5}, past)
+	if d, exp := s.Get(ctx, "expired", false); d != nil || !exp.Equal(past) {
+		t.Errorf("expired entry: got %v %v", d, exp)
+	}
+
+	// Captchas survive a restart.
+	if err := Close(s); err != nil {
+		t.Fatal(err)
+	}
+	s = openBolt(t, path)
+	defer Close(s)
+	if d, exp := s.Get(ctx, "a", false); !bytes.Equal(d, []byte{1, 2, 3}) || !exp.Equal(later) {
+		t.Errorf("after reopening: got %v %v", d, exp)
+	}
+	if d, _ := s.Get(ctx, "a", true); d == nil {
+		t.Errorf("consuming Get failed")
+	}
+	if d, exp := s.Get(ctx, "a", false); d != nil || !exp.IsZero() {
+		t.Errorf("consumed entry: got %v %v", d, exp)
+	}
+	if n, _ := Len(s); n != 2 {
+		t.Errorf("got %d entries, expected 2", n)
+	}
+}
+
+func TestBoltStoreConsumeOnce(t *testing.T) {
+	s := openBolt(t, boltPath(t))
+	defer Close(s)
+	ctx := context.Background()
+	s.Set(ctx, "a", []byte{1}, time.Time{})
+
+	var wg sync.WaitGroup
+	found := make(chan bool, 10)
+	for i := 0; i < cap(found); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, _ := s.Get(ctx, "a", true)
+			found <- d != nil
+		}()
+	}
+	wg.Wait()
+	close(found)
+	n := 0
+	for f := range found {
+		if f {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("captcha consumed %d times", n)
+	}
+}
+
+func TestBoltStoreCollect(t *testing.T) {
+	s := openBolt(t, boltPath(t))
+	defer Close(s)
+	ctx := context.Background()
+	past := time.Now().Add(-2 * boltSlotWidth)
+	for _, id := range []string{"a", "b", "c"} {
+		s.Set(ctx, id, []byte{1}, past)
+	}
+	s.Set(ctx, "later", []byte{1}, time.Now().Add(time.Hour))
+	// Reloading moves a captcha to the slot of its new expiration.
+	s.Set(ctx, "c", []byte{2}, time.Now().Add(time.Hour))
+
+	Collect(s)
+	if n, _ := Len(s); n != 2 {
+		t.Errorf("got %d entries after collection, expected 2", n)
+	}
+	for _, id := range []string{"later", "c"} {
+		if d, _ := s.Get(ctx, id, false); d == nil {
+			t.Errorf("%s collected before it expired", id)
+		}
+	}
+}
+
+func TestBoltStoreSweeper(t *testing.T) {
+	s := openBolt(t, boltPath(t), WithSweeper(time.Millisecond))
+	s.Set(context.Background(), "a", []byte{1}, time.Now().Add(-2*boltSlotWidth))
+	deadline := time.Now().Add(10 * time.Second)
+	for n, _ := Len(s); n != 0; n, _ = Len(s) {
+		if time.Now().After(deadline) {
+			t.Fatal("sweeper didn't remove the expired captcha")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := Close(s); err != nil {
+		t.Fatal(err)
+	}
+}
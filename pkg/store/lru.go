@@ -0,0 +1,247 @@
+package store
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Reasons of evictions, passed to the functions registered with
+// Evicter.OnEvict.
+const (
+	EvictExpired  = "expired"  // the captcha expired before it was consumed
+	EvictCapacity = "capacity" // the store was full
+)
+
+// Evicter is implemented by stores that remove captchas before they are
+// consumed. OnEvict registers a function called with the reason of every
+// eviction. It's called with the store locked, so it must not use the store.
+type Evicter interface {
+	OnEvict(f func(reason string))
+}
+
+// LRUOption configures the store created by NewLRUStore.
+type LRUOption func(*lruStore)
+
+// WithJanitor removes the expired captchas every interval in a background
+// goroutine, stopped by Close. Without it, expired captchas are only removed
+// when they are looked up, evicted for room or collected with Collect.
+func WithJanitor(interval time.Duration) LRUOption {
+	return func(s *lruStore) {
+		s.janitorInterval = interval
+	}
+}
+
+// WithClock makes the store tell the time with now instead of time.Now.
+func WithClock(now func() time.Time) LRUOption {
+	return func(s *lruStore) {
+		s.now = now
+	}
+}
+
+// lruEntry is a captcha in lruStore, both in its recency list and in its
+// expiration heap.
+type lruEntry struct {
+	id        string
+	digits    []byte
+	expiresAt time.Time
+	index     int // in the expiration heap
+}
+
+// expirationHeap orders entries by expiration time, soonest first.
+type expirationHeap []*lruEntry
+
+func (h expirationHeap) Len() int           { return len(h) }
+func (h expirationHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expirationHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expirationHeap) Push(x interface{}) {
+	e := x.(*lruEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expirationHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lruStore is a memory store holding at most a fixed number of captchas. When
+// it's full, storing a captcha evicts the least recently used one. Every
+// captcha can be found, moved or removed in constant time through the map of
+// its list element, and expired ones are removed soonest first through a heap.
+type lruStore struct {
+	mu      sync.Mutex
+	byId    map[string]*list.Element
+	recency *list.List // of *lruEntry, most recently used first
+	expiry  expirationHeap
+
+	capacity   int
+	expiration time.Duration
+	now        func() time.Time
+	onEvict    []func(reason string)
+
+	janitorInterval time.Duration
+	stop            chan struct{}
+	stopped         sync.WaitGroup
+	closeOnce       sync.Once
+}
+
+// NewLRUStore returns a memory store holding at most capacity captchas, which
+// expire after the given duration unless they are set with an expiration
+// time. It panics if capacity isn't positive.
+func NewLRUStore(capacity int, expiration time.Duration, opts ...LRUOption) Store {
+	if capacity <= 0 {
+		panic("store: capacity of LRU store must be positive")
+	}
+	s := &lruStore{
+		byId:       make(map[string]*list.Element),
+		recency:    list.New(),
+		capacity:   capacity,
+		expiration: expiration,
+		now:        time.Now,
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.janitorInterval > 0 {
+		s.stopped.Add(1)
+		go s.janitor()
+	}
+	return s
+}
+
+func (s *lruStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		expiresAt = s.now().Add(s.expiration)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.byId[id]; ok {
+		// Reloaded captchas replace their entry rather than adding one.
+		e := el.Value.(*lruEntry)
+		e.digits, e.expiresAt = digits, expiresAt
+		heap.Fix(&s.expiry, e.index)
+		s.recency.MoveToFront(el)
+		return
+	}
+	if s.recency.Len() >= s.capacity {
+		s.removeExpired(s.now())
+	}
+	if s.recency.Len() >= s.capacity {
+		s.remove(s.recency.Back(), EvictCapacity)
+	}
+	e := &lruEntry{id: id, digits: digits, expiresAt: expiresAt}
+	heap.Push(&s.expiry, e)
+	s.byId[id] = s.recency.PushFront(e)
+}
+
+func (s *lruStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.byId[id]
+	if !ok {
+		return nil, time.Time{}
+	}
+	e := el.Value.(*lruEntry)
+	switch {
+	case !s.now().Before(e.expiresAt):
+		s.remove(el, EvictExpired)
+		return nil, e.expiresAt
+	case clear:
+		s.remove(el, "")
+	default:
+		s.recency.MoveToFront(el)
+	}
+	return e.digits, e.expiresAt
+}
+
+// remove removes the entry of el, and reports its eviction for the given
+// reason if it's not empty.
+func (s *lruStore) remove(el *list.Element, reason string) {
+	e := s.recency.Remove(el).(*lruEntry)
+	heap.Remove(&s.expiry, e.index)
+	delete(s.byId, e.id)
+	if reason == "" {
+		return
+	}
+	for _, f := range s.onEvict {
+		f(reason)
+	}
+}
+
+// removeExpired removes the captchas that expired at now.
+func (s *lruStore) removeExpired(now time.Time) {
+	for len(s.expiry) > 0 && !now.Before(s.expiry[0].expiresAt) {
+		s.remove(s.byId[s.expiry[0].id], EvictExpired)
+	}
+}
+
+// Collect removes the expired captchas now.
+func (s *lruStore) Collect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired(s.now())
+}
+
+// Len returns the number of captchas in the store, including expired ones
+// that are not removed yet.
+func (s *lruStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recency.Len()
+}
+
+// Dump returns the unexpired captchas, least recently used first, so that
+// setting them in order in an empty store restores their recency.
+func (s *lruStore) Dump() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	entries := make([]Entry, 0, s.recency.Len())
+	for el := s.recency.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*lruEntry); now.Before(e.expiresAt) {
+			entries = append(entries, Entry{e.id, e.digits, e.expiresAt})
+		}
+	}
+	return entries
+}
+
+// OnEvict registers a function called with the reason of every eviction.
+func (s *lruStore) OnEvict(f func(reason string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = append(s.onEvict, f)
+}
+
+func (s *lruStore) janitor() {
+	defer s.stopped.Done()
+	t := time.NewTicker(s.janitorInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Collect()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the janitor and waits for it to return.
+func (s *lruStore) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	s.stopped.Wait()
+	return nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for WithClock that only moves when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// evictions returns the evictions of s by reason.
+func evictions(s Store) map[string]int {
+	m := make(map[string]int)
+	s.(Evicter).OnEvict(func(reason string) { m[reason]++ })
+	return m
+}
+
+func TestLRUStore(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1e9, 0)}
+	s := NewLRUStore(10, time.Minute, WithClock(clock.now))
+	evicted := evictions(s)
+	ctx := context.Background()
+
+	s.Set(ctx, "a", []byte{1}, time.Time{})
+	if d, exp := s.Get(ctx, "a", false); !bytes.Equal(d, []byte{1}) || !exp.Equal(clock.t.Add(time.Minute)) {
+		t.Errorf("Get: got %v %v", d, exp)
+	}
+	// Reloads replace the entry.
+	s.Set(ctx, "a", []byte{2}, clock.t.Add(time.Second))
+	if n, _ := Len(s); n != 1 {
+		t.Errorf("set twice: got %d entries", n)
+	}
+	if d, _ := s.Get(ctx, "a", true); !bytes.Equal(d, []byte{2}) {
+		t.Errorf("Get after reload: got %v", d)
+	}
+	if d, exp := s.Get(ctx, "a", false); d != nil || !exp.IsZero() {
+		t.Errorf("Get after clear: got %v %v", d, exp)
+	}
+
+	s.Set(ctx, "b", []byte{1}, clock.t.Add(time.Second))
+	clock.advance(time.Second)
+	if d, exp := s.Get(ctx, "b", false); d != nil || !exp.Equal(clock.t) {
+		t.Errorf("Get of expired entry: got %v %v", d, exp)
+	}
+	if n, _ := Len(s); n != 0 || evicted[EvictExpired] != 1 {
+		t.Errorf("expired entry not evicted: %d entries, %v", n, evicted)
+	}
+	if evicted[EvictCapacity] != 0 {
+		t.Errorf("unexpected evictions: %v", evicted)
+	}
+}
+
+func TestLRUStoreCapacity(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1e9, 0)}
+	s := NewLRUStore(3, time.Minute, WithClock(clock.now))
+	evicted := evictions(s)
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c"} {
+		s.Set(ctx, id, []byte(id), time.Time{})
+	}
+	s.Get(ctx, "a", false) // b is now the least recently used
+	s.Set(ctx, "d", []byte("d"), time.Time{})
+	if d, _ := s.Get(ctx, "b", false); d != nil {
+		t.Errorf("least recently used entry kept")
+	}
+	for _, id := range []string{"a", "c", "d"} {
+		if d, _ := s.Get(ctx, id, false); d == nil {
+			t.Errorf("%s evicted", id)
+		}
+	}
+	if evicted[EvictCapacity] != 1 {
+		t.Errorf("evictions: got %v", evicted)
+	}
+
+	// Expired entries make room before used ones.
+	s.Set(ctx, "c", []byte("c"), clock.t.Add(time.Second))
+	clock.advance(time.Second)
+	s.Set(ctx, "e", []byte("e"), time.Time{})
+	if d, _ := s.Get(ctx, "a", false); d == nil {
+		t.Errorf("unexpired entry evicted instead of an expired one")
+	}
+	if evicted[EvictCapacity] != 1 || evicted[EvictExpired] != 1 {
+		t.Errorf("evictions: got %v", evicted)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Set(ctx, fmt.Sprint(i), []byte{1}, time.Time{})
+	}
+	if n, _ := Len(s); n != 3 {
+		t.Errorf("capacity exceeded: %d entries", n)
+	}
+}
+
+func TestLRUStoreCollect(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1e9, 0)}
+	s := NewLRUStore(100, time.Minute, WithClock(clock.now))
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		s.Set(ctx, fmt.Sprint(i), []byte{1}, clock.t.Add(time.Duration(i)*time.Second))
+	}
+	clock.advance(5 * time.Second)
+	Collect(s)
+	// Captchas expire at their expiration time.
+	if n, _ := Len(s); n != 4 {
+		t.Errorf("got %d entries after collection, expected 4", n)
+	}
+	for i := 6; i < 10; i++ {
+		if d, _ := s.Get(ctx, fmt.Sprint(i), false); d == nil {
+			t.Errorf("%d collected before it expired", i)
+		}
+	}
+}
+
+func TestLRUStoreJanitor(t *testing.T) {
+	s := NewLRUStore(100, -time.Second, WithJanitor(time.Millisecond))
+	collected := make(chan string, 1)
+	s.(Evicter).OnEvict(func(reason string) { collected <- reason })
+	s.Set(context.Background(), "a", []byte{1}, time.Time{})
+	select {
+	case reason := <-collected:
+		if reason != EvictExpired {
+			t.Errorf("evicted for %q", reason)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("janitor didn't collect the expired entry")
+	}
+	if err := Close(s); err != nil {
+		t.Fatal(err)
+	}
+	Close(s) // Close can be called twice.
+}
@@ -2,31 +2,110 @@ package store
 
 import (
 	"context"
-	pgx "github.com/jackc/pgx/v4/pgxpool"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
 // postgresStore is an internal store for captcha ids and their values.
 type postgresStore struct {
-	pgx *pgx.Pool
+	pgx        *pgxpool.Pool
+	expiration time.Duration
+
+	sweepInterval time.Duration
+	stop          chan struct{}
+	stopped       sync.WaitGroup
+	closeOnce     sync.Once
 }
 
-// NewPostgresStore returns a new standard memory store for captchas with the
-// given collection threshold and expiration time (duration). The returned
-// store must be registered with SetCustomStore to replace the default one.
-func NewPostgresStore(ctx context.Context) Store {
-	return &postgresStore{
-		pgx: connectDB(ctx),
+// NewPostgresStore returns a new store for captchas backed by the postgres
+// database of cfg, which expire after the given duration unless they are set
+// with an expiration time. It creates the captchas table if it doesn't exist,
+// and retries connecting cfg.ConnectAttempts times before returning an error.
+func NewPostgresStore(ctx context.Context, cfg PostgresConfig, expiration time.Duration) (Store, error) {
+	pool, err := connectDB(ctx, cfg, Schema, statements)
+	if err != nil {
+		return nil, err
+	}
+	pgs := &postgresStore{
+		pgx:           pool,
+		expiration:    expiration,
+		sweepInterval: cfg.SweepInterval,
+		stop:          make(chan struct{}),
 	}
+	if pgs.sweepInterval > 0 {
+		pgs.stopped.Add(1)
+		go pgs.sweeper()
+	}
+	return pgs, nil
 }
 
-func (pgs *postgresStore) Set(id string, digits []byte) {
+// Ping checks that the database can be reached.
+func (pgs *postgresStore) Ping(ctx context.Context) error {
+	return pgs.pgx.Ping(ctx)
+}
 
+// Close stops the sweeper and closes the connections of the store.
+func (pgs *postgresStore) Close() error {
+	pgs.closeOnce.Do(func() {
+		close(pgs.stop)
+		pgs.stopped.Wait()
+		pgs.pgx.Close()
+	})
+	return nil
 }
 
-func (pgs *postgresStore) Get(id string, clear bool) (digits []byte) {
+func (pgs *postgresStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(pgs.expiration)
+	}
+	if _, err := pgs.pgx.Exec(ctx, stmtInsert, id, digits, expiresAt); err != nil {
+		log.Errorf("postgres store: failed to set captcha: %v", err)
+	}
+}
 
+// Get returns the digits of the captcha. Clearing it deletes the row in the
+// statement that reads it, so a captcha is consumed at most once.
+func (pgs *postgresStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
+	stmt := stmtSelect
 	if clear {
+		stmt = stmtConsume
+	}
+	var solution []byte
+	err := pgs.pgx.QueryRow(ctx, stmt, id).Scan(&solution, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, time.Time{}
+	}
+	if err != nil {
+		log.Errorf("postgres store: failed to get captcha: %v", err)
+		return nil, time.Time{}
+	}
+	if !time.Now().Before(expiresAt) {
+		return nil, expiresAt
+	}
+	return solution, expiresAt
+}
+
+// Collect deletes the expired captchas.
+func (pgs *postgresStore) Collect() {
+	if _, err := pgs.pgx.Exec(context.Background(), stmtDeleteExpired); err != nil {
+		log.Errorf("postgres store: failed to collect expired captchas: %v", err)
+	}
+}
 
+func (pgs *postgresStore) sweeper() {
+	defer pgs.stopped.Done()
+	t := time.NewTicker(pgs.sweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			pgs.Collect()
+		case <-pgs.stop:
+			return
+		}
 	}
-	return
 }
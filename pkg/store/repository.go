@@ -2,42 +2,195 @@ package store
 
 import (
 	"context"
-	"os"
-
-	pgx "github.com/jackc/pgx/v4/pgxpool"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Schema creates the table of the postgres store. The solution column holds
+// the hashed and sealed record built by the captcha package, never the plain
+// digits.
+const Schema = `CREATE TABLE IF NOT EXISTS captchas (
+	id         TEXT PRIMARY KEY,
+	solution   BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+ALTER TABLE captchas ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS captchas_expires_at ON captchas (expires_at);`
+
+// Names of the statements prepared on every connection of the pool.
+const (
+	stmtSelect        = "captcha_select"
+	stmtInsert        = "captcha_insert"
+	stmtConsume       = "captcha_consume"
+	stmtDeleteExpired = "captcha_delete_expired"
 )
 
-// Connect to postgres database
-func connectDB(ctx context.Context) *pgx.Pool {
-	dbURL, success := os.LookupEnv("DATABASE_URL")
-	if !success {
-		log.Fatalf("no environment-variable set for postgres DATABASE_URL")
-		os.Exit(1)
+// statements maps the names of the prepared statements to their SQL.
+var statements = map[string]string{
+	stmtSelect:        SelectCaptcha(),
+	stmtInsert:        InsertCaptcha(),
+	stmtConsume:       ConsumeCaptcha(),
+	stmtDeleteExpired: DeleteExpiredCaptchas(),
+}
+
+// PostgresConfig configures the connection pool of the postgres store. Zero
+// values leave the pgxpool defaults, or those of the URL's pool_max_conns,
+// pool_min_conns, ... parameters.
+type PostgresConfig struct {
+	URL               string
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementCacheCapacity is the number of statements other than the
+	// store's own that each connection keeps prepared; negative disables
+	// the cache.
+	StatementCacheCapacity int
+	// ConnectAttempts is the number of attempts to connect on startup, the
+	// first retry waiting ConnectBackoff, and each following one twice as
+	// long as the previous one, up to maxConnectBackoff.
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+	// SweepInterval, if set, is the interval of the deletions of expired
+	// captchas.
+	SweepInterval time.Duration
+}
+
+// maxConnectBackoff bounds the wait between attempts to connect.
+const maxConnectBackoff = 30 * time.Second
+
+// poolConfig returns the pgxpool configuration of c, preparing the given
+// statements, by name, on every connection.
+func (c PostgresConfig) poolConfig(statements map[string]string) (*pgxpool.Config, error) {
+	if c.URL == "" {
+		return nil, errors.New("store: no postgres database url configured")
+	}
+	pc, err := pgxpool.ParseConfig(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxConns > 0 {
+		pc.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		pc.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		pc.MaxConnLifetime = c.MaxConnLifetime
 	}
+	if c.MaxConnIdleTime > 0 {
+		pc.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.HealthCheckPeriod > 0 {
+		pc.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+	switch {
+	case c.StatementCacheCapacity < 0:
+		pc.ConnConfig.BuildStatementCache = nil
+	case c.StatementCacheCapacity > 0:
+		capacity := c.StatementCacheCapacity
+		pc.ConnConfig.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			return stmtcache.New(conn, stmtcache.ModePrepare, capacity)
+		}
+	}
+	if len(statements) > 0 {
+		pc.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			for name, sql := range statements {
+				if _, err := conn.Prepare(ctx, name, sql); err != nil {
+					return fmt.Errorf("preparing %s: %v", name, err)
+				}
+			}
+			return nil
+		}
+	}
+	return pc, nil
+}
+
+// ConnectPostgres returns a pool of connections to the postgres database of
+// cfg, after creating the given schema. It retries with backoff while the
+// database can't be reached, like the postgres store, for the other users of
+// the database.
+func ConnectPostgres(ctx context.Context, cfg PostgresConfig, schema string) (*pgxpool.Pool, error) {
+	return connectDB(ctx, cfg, schema, nil)
+}
 
-	conn, err := pgx.Connect(ctx, dbURL)
+// connectDB connects to the postgres database of cfg and creates the schema,
+// retrying with backoff while the database can't be reached.
+func connectDB(ctx context.Context, cfg PostgresConfig, schema string, statements map[string]string) (*pgxpool.Pool, error) {
+	pc, err := cfg.poolConfig(statements)
 	if err != nil {
-		log.Fatalf("could not connect to postgres database: %s", err)
-		os.Exit(1)
+		return nil, err
 	}
+	// The schema must exist before the statements are prepared, so it's
+	// created on a connection of its own.
+	schemaConfig := pc.ConnConfig.Copy()
 
-	log.Info("successfully connected to postgres database")
-	return conn
+	backoff := cfg.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = createSchema(ctx, schemaConfig, schema)
+		var pool *pgxpool.Pool
+		if err == nil {
+			pool, err = pgxpool.ConnectConfig(ctx, pc)
+		}
+		if err == nil {
+			log.Info("successfully connected to postgres database")
+			return pool, nil
+		}
+		if attempt >= cfg.ConnectAttempts {
+			return nil, fmt.Errorf("store: could not connect to postgres database after %d attempts: %v", attempt, err)
+		}
+		log.Warnf("could not connect to postgres database, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// createSchema creates the tables of the schema if they don't exist.
+func createSchema(ctx context.Context, cc *pgx.ConnConfig, schema string) error {
+	conn, err := pgx.ConnectConfig(ctx, cc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, schema)
+	return err
 }
 
-// SelectCaptcha returns a PG transaction string that queries a Captcha Rows
+// SelectCaptcha returns a PG transaction string that queries the solution and
+// expiration of a Captcha Row by ID.
 func SelectCaptcha() string {
-	return "SELECT (solution, pub_key) FROM captchas WHERE id = $1;"
+	return "SELECT solution, expires_at FROM captchas WHERE id = $1;"
 }
 
-// InsertCaptcha returns a PG transaction string that creates an Captcha Row
+// InsertCaptcha returns a PG transaction string that creates a Captcha Row, or
+// replaces the solution and expiration of a reloaded one.
 func InsertCaptcha() string {
-	return "INSERT INTO captchas (id, solution) VALUES ($1, $2);"
+	return `INSERT INTO captchas (id, solution, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET solution = EXCLUDED.solution, expires_at = EXCLUDED.expires_at;`
 }
 
-// DeleteCaptcha returns a PG transaction string that deletes an Captcha Row by ID
-func DeleteCaptcha() string {
-	return "DELETE FROM captchas WHERE id = $1" // TODO https://stackoverflow.com/questions/26046816/is-there-a-way-to-set-an-expiry-time-after-which-a-data-entry-is-automaticall
+// ConsumeCaptcha returns a PG transaction string that deletes a Captcha Row by
+// ID and returns its solution and expiration, so that concurrent consumers
+// can't both get it.
+func ConsumeCaptcha() string {
+	return "DELETE FROM captchas WHERE id = $1 RETURNING solution, expires_at;"
 }
 
+// DeleteExpiredCaptchas returns a PG transaction string that deletes the
+// expired Captcha Rows.
+func DeleteExpiredCaptchas() string {
+	return "DELETE FROM captchas WHERE expires_at <= now();"
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// shardedStore spreads captchas across independently locked stores by the
+// hash of their id, so that concurrent requests rarely wait for each other.
+type shardedStore struct {
+	shards []Store
+}
+
+// NewShardedStore returns a store spreading captchas across n shards created
+// by newShard, such as LRU stores with their own TTL index. The sharded store
+// is a Sizer, Collector, Evicter, Dumper and Closer through its shards. It
+// panics if n isn't positive.
+//
+// A capacity of the shards bounds the number of captchas of each of them, so
+// the least recently used captcha of the whole store isn't always the first
+// evicted.
+func NewShardedStore(n int, newShard func() Store) Store {
+	if n <= 0 {
+		panic("store: number of shards must be positive")
+	}
+	s := &shardedStore{shards: make([]Store, n)}
+	for i := range s.shards {
+		s.shards[i] = newShard()
+	}
+	return s
+}
+
+// shard returns the shard of the captcha id, by its FNV-1a hash.
+func (s *shardedStore) shard(id string) Store {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *shardedStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	s.shard(id).Set(ctx, id, digits, expiresAt)
+}
+
+func (s *shardedStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
+	return s.shard(id).Get(ctx, id, clear)
+}
+
+// Len returns the number of captchas of the shards that are Sizers.
+func (s *shardedStore) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		l, _ := Len(sh)
+		n += l
+	}
+	return n
+}
+
+// Collect collects the shards that are Collectors, one at a time.
+func (s *shardedStore) Collect() {
+	for _, sh := range s.shards {
+		Collect(sh)
+	}
+}
+
+// Dump returns the unexpired captchas of the shards that are Dumpers, shard
+// after shard.
+func (s *shardedStore) Dump() []Entry {
+	var entries []Entry
+	for _, sh := range s.shards {
+		e, _ := Dump(sh)
+		entries = append(entries, e...)
+	}
+	return entries
+}
+
+// OnEvict registers f with the shards that are Evicters. Shards evict
+// independently, so f may be called concurrently.
+func (s *shardedStore) OnEvict(f func(reason string)) {
+	for _, sh := range s.shards {
+		if e, ok := unwrap(sh).(Evicter); ok {
+			e.OnEvict(f)
+		}
+	}
+}
+
+// Close closes the shards, and returns the first error.
+func (s *shardedStore) Close() error {
+	var err error
+	for _, sh := range s.shards {
+		if e := Close(sh); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/roachapp/captcha/pkg/util"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedStore(t *testing.T) {
+	s := NewShardedStore(8, func() Store { return NewLRUStore(100, time.Minute) })
+	var evicted int32
+	s.(Evicter).OnEvict(func(string) { atomic.AddInt32(&evicted, 1) })
+	ctx := context.Background()
+
+	ids := make([]string, 200)
+	for i := range ids {
+		ids[i] = util.RandomId()
+		s.Set(ctx, ids[i], []byte(ids[i]), time.Time{})
+	}
+	if n, _ := Len(s); n != len(ids) {
+		t.Errorf("got %d captchas, expected %d", n, len(ids))
+	}
+	for _, sh := range s.(*shardedStore).shards {
+		if n, _ := Len(sh); n == 0 || n == len(ids) {
+			t.Errorf("captchas not spread across shards: %d in a shard", n)
+		}
+	}
+	for _, id := range ids {
+		if d, _ := s.Get(ctx, id, true); !bytes.Equal(d, []byte(id)) {
+			t.Fatalf("Get(%q): got %q", id, d)
+		}
+	}
+	if n, _ := Len(s); n != 0 {
+		t.Errorf("got %d captchas after clearing them all", n)
+	}
+
+	s.Set(ctx, "expired", []byte{1}, time.Now().Add(-time.Second))
+	Collect(s)
+	if atomic.LoadInt32(&evicted) != 1 {
+		t.Errorf("got %d evictions, expected 1", evicted)
+	}
+	if err := Close(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// benchmarkParallel sets and consumes captchas from parallel goroutines.
+func benchmarkParallel(b *testing.B, s Store) {
+	d := util.RandomDigits(10)
+	ctx := context.Background()
+	var worker int32
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := fmt.Sprintf("%d-", atomic.AddInt32(&worker, 1))
+		ids := make([]string, 1000)
+		for i := range ids {
+			ids[i] = prefix + util.RandomId()
+		}
+		for i := 0; pb.Next(); i++ {
+			id := ids[i%len(ids)]
+			s.Set(ctx, id, d, time.Time{})
+			s.Get(ctx, id, true)
+		}
+	})
+}
+
+func BenchmarkParallelCacheStore(b *testing.B) {
+	benchmarkParallel(b, NewCacheStore(9999, time.Minute))
+}
+
+func BenchmarkParallelLRUStore(b *testing.B) {
+	benchmarkParallel(b, NewLRUStore(100000, time.Minute))
+}
+
+func BenchmarkParallelShardedStore(b *testing.B) {
+	benchmarkParallel(b, NewShardedStore(16, func() Store { return NewLRUStore(100000/16, time.Minute) }))
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is a captcha held by a store, with its expiration time.
+type Entry struct {
+	ID        string
+	Digits    []byte
+	ExpiresAt time.Time
+}
+
+// Dumper is implemented by memory stores that can list their captchas. Dump
+// returns the unexpired ones, least recently used first where the store
+// knows.
+type Dumper interface {
+	Dump() []Entry
+}
+
+// Dump returns the unexpired captchas of the store, and false if it doesn't
+// implement Dumper.
+func Dump(s Store) ([]Entry, bool) {
+	if d, ok := unwrap(s).(Dumper); ok {
+		return d.Dump(), true
+	}
+	return nil, false
+}
+
+// CanDump returns true if the store, or the store it wraps, implements Dumper.
+func CanDump(s Store) bool {
+	_, ok := unwrap(s).(Dumper)
+	return ok
+}
+
+// A snapshot file holds the captchas of a memory store, sealed with a key
+// derived from the configured one:
+//
+//   snapshot = snapshotMagic || nonce ||
+//              AES-GCM(HMAC(key, snapshotKeyPurpose), nonce, gob([]Entry),
+//                      additional data = snapshotMagic)
+//
+const (
+	snapshotMagic      = "captcha-snapshot-1\n"
+	snapshotKeyPurpose = "captcha snapshot"
+)
+
+// ErrNotDumper is returned by SaveSnapshot for stores that can't list their
+// captchas.
+var ErrNotDumper = errors.New("store: store can't be snapshotted")
+
+// snapshotCipher returns the AEAD sealing snapshots with the given key.
+func snapshotCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, errors.New("store: empty snapshot key")
+	}
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, snapshotKeyPurpose)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveSnapshot writes the unexpired captchas of s to the file at path,
+// encrypted with key, and returns their number. The file is replaced
+// atomically, and is only readable by its owner.
+func SaveSnapshot(s Store, path string, key []byte) (int, error) {
+	entries, ok := Dump(s)
+	if !ok {
+		return 0, ErrNotDumper
+	}
+	aead, err := snapshotCipher(key)
+	if err != nil {
+		return 0, err
+	}
+	var plain bytes.Buffer
+	if err := gob.NewEncoder(&plain).Encode(entries); err != nil {
+		return 0, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	out := append([]byte(snapshotMagic), nonce...)
+	out = aead.Seal(out, nonce, plain.Bytes(), []byte(snapshotMagic))
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(out)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// SnapshotRemoveError is returned by RestoreSnapshot when the snapshot file
+// can't be removed. Nothing is restored then, since the file would be
+// restored again on the next start.
+type SnapshotRemoveError struct {
+	Path string
+	Err  error
+}
+
+func (e *SnapshotRemoveError) Error() string {
+	return fmt.Sprintf("store: can't remove snapshot %s, not restoring it: %v", e.Path, e.Err)
+}
+
+// removeSnapshot removes a restored snapshot file. Tests replace it.
+var removeSnapshot = os.Remove
+
+// RestoreSnapshot sets the captchas of the snapshot file at path in s,
+// skipping the ones that expired since, and returns their number. The file is
+// removed before they are restored, so that the captchas consumed afterwards
+// can't be restored again, and a *SnapshotRemoveError is returned if that
+// fails. A missing file restores nothing.
+func RestoreSnapshot(ctx context.Context, s Store, path string, key []byte) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	aead, err := snapshotCipher(key)
+	if err != nil {
+		return 0, err
+	}
+	header := len(snapshotMagic) + aead.NonceSize()
+	if len(data) < header || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("store: %s is not a snapshot", path)
+	}
+	plain, err := aead.Open(nil, data[len(snapshotMagic):header], data[header:], []byte(snapshotMagic))
+	if err != nil {
+		return 0, fmt.Errorf("store: can't decrypt snapshot %s, wrong key?", path)
+	}
+	var entries []Entry
+	if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&entries); err != nil {
+		return 0, fmt.Errorf("store: corrupt snapshot %s: %v", path, err)
+	}
+	if err := removeSnapshot(path); err != nil {
+		return 0, &SnapshotRemoveError{path, err}
+	}
+
+	now, n := time.Now(), 0
+	for _, e := range entries {
+		if now.Before(e.ExpiresAt) {
+			s.Set(ctx, e.ID, e.Digits, e.ExpiresAt)
+			n++
+		}
+	}
+	return n, nil
+}
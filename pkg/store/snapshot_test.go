@@ -0,0 +1,94 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.snap")
+	key := []byte("snapshot key")
+	ctx := context.Background()
+
+	later := time.Now().Add(time.Hour)
+	for _, s := range []Store{
+		NewCacheStore(100, time.Minute),
+		NewLRUStore(100, time.Minute),
+		NewShardedStore(4, func() Store { return NewLRUStore(100, time.Minute) }),
+	} {
+		s.Set(ctx, "a", []byte("secret solution"), later)
+		s.Set(ctx, "b", []byte{2}, time.Now().Add(50*time.Millisecond))
+		s.Set(ctx, "expired", []byte{3}, time.Now().Add(-time.Second))
+		if n, err := SaveSnapshot(s, path, key); err != nil || n != 2 {
+			t.Fatalf("%T: SaveSnapshot: %d, %v", s, n, err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret solution")) {
+			t.Errorf("%T: snapshot isn't encrypted", s)
+		}
+		if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+			t.Errorf("%T: snapshot mode %v", s, fi.Mode())
+		}
+
+		if _, err := RestoreSnapshot(ctx, NewLRUStore(100, time.Minute), path, []byte("wrong key")); err == nil {
+			t.Errorf("%T: restored with the wrong key", s)
+		}
+		time.Sleep(50 * time.Millisecond) // b expires
+		restored := NewLRUStore(100, time.Minute)
+		if n, err := RestoreSnapshot(ctx, restored, path, key); err != nil || n != 1 {
+			t.Fatalf("%T: RestoreSnapshot: %d, %v", s, n, err)
+		}
+		if d, exp := restored.Get(ctx, "a", false); !bytes.Equal(d, []byte("secret solution")) || !exp.Equal(later) {
+			t.Errorf("%T: restored %q %v", s, d, exp)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%T: snapshot not removed after restore: %v", s, err)
+		}
+	}
+
+	if n, err := RestoreSnapshot(ctx, NewLRUStore(100, time.Minute), path, key); err != nil || n != 0 {
+		t.Errorf("restore of a missing snapshot: %d, %v", n, err)
+	}
+	if _, err := SaveSnapshot(&instrumented{NewLRUStore(1, time.Minute)}, path, nil); err == nil {
+		t.Errorf("saved with an empty key")
+	}
+
+	// A snapshot that can't be removed isn't restored, so that it isn't
+	// restored twice.
+	saved := NewLRUStore(100, time.Minute)
+	saved.Set(ctx, "a", []byte{1}, time.Time{})
+	if _, err := SaveSnapshot(saved, path, key); err != nil {
+		t.Fatal(err)
+	}
+	removeSnapshot = func(string) error { return os.ErrPermission }
+	defer func() { removeSnapshot = os.Remove }()
+	restored := NewLRUStore(100, time.Minute)
+	n, err := RestoreSnapshot(ctx, restored, path, key)
+	if _, ok := err.(*SnapshotRemoveError); !ok || n != 0 {
+		t.Errorf("restore of a snapshot that can't be removed: %d, %v", n, err)
+	}
+	if d, _ := restored.Get(ctx, "a", false); d != nil {
+		t.Errorf("captcha restored from a snapshot that can't be removed")
+	}
+}
+
+// instrumented wraps a store like the instrumented stores of the metrics
+// package.
+type instrumented struct {
+	Store
+}
+
+func (s *instrumented) Unwrap() Store { return s.Store }
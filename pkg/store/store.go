@@ -5,7 +5,8 @@
 package store
 
 import (
-	"container/list"
+	"container/heap"
+	"context"
 	"sync"
 	"time"
 )
@@ -18,61 +19,205 @@ import (
 // when necessary (for example, the default memory store collects them in Set
 // method after the certain amount of captchas has been stored.)
 type Store interface {
-	// Set sets the digits for the captcha id.
-	Set(id string, digits []byte)
+	// Set sets the digits for the captcha id, until expiresAt. A zero
+	// expiresAt leaves the expiration to the store's default, if any.
+	Set(ctx context.Context, id string, digits []byte, expiresAt time.Time)
 
-	// Get returns stored digits for the captcha id. Clear indicates
-	// whether the captcha must be deleted from the store.
-	Get(id string, clear bool) (digits []byte)
+	// Get returns stored digits for the captcha id and their expiration
+	// time. Clear indicates whether the captcha must be deleted from the
+	// store. Expired captchas are never returned: if the store still holds
+	// one, digits are nil and expiresAt is in the past, and both are zero if
+	// there is no such captcha.
+	Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time)
 }
 
-// expValue stores timestamp and id of captchas. It is used in the list inside
-// cacheStore for indexing generated captchas by timestamp to enable garbage
-// collection of expired captchas.
-type idByTimeValue struct {
-	timestamp time.Time
+// Closer is implemented by stores that hold resources, such as database
+// connections or background goroutines. Close flushes pending writes and
+// releases the resources; the store must not be used afterwards.
+type Closer interface {
+	Close() error
+}
+
+// Pinger is implemented by stores that depend on a remote service. Ping
+// returns an error if the service can't be reached.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping pings the store if it implements Pinger. Other stores are always
+// reachable.
+func Ping(ctx context.Context, s Store) error {
+	if p, ok := s.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Sizer is implemented by stores that know how many captchas they hold.
+type Sizer interface {
+	Len() int
+}
+
+// Collector is implemented by stores that remove expired captchas in
+// batches. Collect runs a collection now.
+type Collector interface {
+	Collect()
+}
+
+// Wrapper is implemented by stores that wrap another one, like the
+// instrumented stores of the metrics package. Len and Collect look through
+// wrappers.
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// unwrap returns the innermost store wrapped by s.
+func unwrap(s Store) Store {
+	for {
+		w, ok := s.(Wrapper)
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// Len returns the number of captchas in the store, and false if it doesn't
+// implement Sizer.
+func Len(s Store) (int, bool) {
+	if sz, ok := unwrap(s).(Sizer); ok {
+		return sz.Len(), true
+	}
+	return 0, false
+}
+
+// Collect runs a collection of the store, and returns false if it doesn't
+// implement Collector.
+func Collect(s Store) bool {
+	if c, ok := unwrap(s).(Collector); ok {
+		c.Collect()
+		return true
+	}
+	return false
+}
+
+// Close closes the store if it implements Closer.
+func Close(s Store) error {
+	if c, ok := s.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// cacheEntry is a captcha in cacheStore, both in its map and in its
+// expiration index.
+type cacheEntry struct {
 	id        string
+	digits    []byte
+	expiresAt time.Time
+	index     int // in the expiration index
+}
+
+// idByTime is the heap indexing the captchas of cacheStore by expiration
+// time, soonest first, so that collections only visit the expired ones.
+type idByTime []*cacheEntry
+
+func (h idByTime) Len() int           { return len(h) }
+func (h idByTime) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h idByTime) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *idByTime) Push(x interface{}) {
+	e := x.(*cacheEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *idByTime) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
 }
 
 // cacheStore is an internal store for captcha ids and their values.
 type cacheStore struct {
 	sync.RWMutex
-	digitsById map[string][]byte
-	idByTime   *list.List
+	digitsById map[string]*cacheEntry
+	idByTime   idByTime
 	// Number of items stored since last collection.
 	numStored int
 	// Number of saved items that triggers collection.
 	collectNum int
-	// Expiration time of captchas.
+	// Expiration time of captchas set without one.
 	expiration time.Duration
+	// Running collections, waited for by Close.
+	collecting sync.WaitGroup
+	closed     bool
 }
 
 // NewCacheStore returns a new standard memory store for captchas with the
-// given collection threshold and expiration time (duration). The returned
-// store must be registered with SetCustomStore to replace the default one.
+// given collection threshold and default expiration time (duration), used for
+// captchas set without one. The returned store must be registered with
+// SetCustomStore to replace the default one.
 func NewCacheStore(collectNum int, expiration time.Duration) Store {
 	return &cacheStore{
-		digitsById: make(map[string][]byte),
-		idByTime: list.New(),
+		digitsById: make(map[string]*cacheEntry),
 		collectNum: collectNum,
 		expiration: expiration,
 	}
 }
 
-func (s *cacheStore) Set(id string, digits []byte) {
+func (s *cacheStore) Set(ctx context.Context, id string, digits []byte, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(s.expiration)
+	}
 	s.Lock()
-	s.digitsById[id] = digits
-	s.idByTime.PushBack(idByTimeValue{time.Now(), id})
+	if e, ok := s.digitsById[id]; ok {
+		// Reloaded captchas replace their entry rather than adding one.
+		e.digits, e.expiresAt = digits, expiresAt
+		heap.Fix(&s.idByTime, e.index)
+	} else {
+		e = &cacheEntry{id: id, digits: digits, expiresAt: expiresAt}
+		heap.Push(&s.idByTime, e)
+		s.digitsById[id] = e
+	}
 	s.numStored++
-	if s.numStored <= s.collectNum {
+	if s.numStored <= s.collectNum || s.closed {
 		s.Unlock()
 		return
 	}
+	s.collecting.Add(1)
 	s.Unlock()
-	go s.collect()
+	go func() {
+		defer s.collecting.Done()
+		s.collect()
+	}()
 }
 
-func (s *cacheStore) Get(id string, clear bool) (digits []byte) {
+// Len returns the number of captchas in the store, including expired ones
+// that are not collected yet.
+func (s *cacheStore) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.digitsById)
+}
+
+// Close stops starting collections and waits for the running ones.
+func (s *cacheStore) Close() error {
+	s.Lock()
+	s.closed = true
+	s.Unlock()
+	s.collecting.Wait()
+	return nil
+}
+
+func (s *cacheStore) Get(ctx context.Context, id string, clear bool) (digits []byte, expiresAt time.Time) {
 	if !clear {
 		// When we don't need to clear captcha, acquire read lock.
 		s.RLock()
@@ -81,37 +226,50 @@ func (s *cacheStore) Get(id string, clear bool) (digits []byte) {
 		s.Lock()
 		defer s.Unlock()
 	}
-	digits, ok := s.digitsById[id]
+	e, ok := s.digitsById[id]
 	if !ok {
 		return
 	}
+	if time.Now().Before(e.expiresAt) {
+		digits = e.digits
+	}
+	expiresAt = e.expiresAt
 	if clear {
 		delete(s.digitsById, id)
-		// XXX(dchest) Index (s.idByTime) will be cleaned when
-		// collecting expired captchas.  Can't clean it here, because
-		// we don't store reference to expValue in the map.
-		// Maybe store it?
+		heap.Remove(&s.idByTime, e.index)
 	}
 	return
 }
 
+// Dump returns the unexpired captchas, in no particular order.
+func (s *cacheStore) Dump() []Entry {
+	now := time.Now()
+	s.RLock()
+	defer s.RUnlock()
+	entries := make([]Entry, 0, len(s.digitsById))
+	for id, e := range s.digitsById {
+		if now.Before(e.expiresAt) {
+			entries = append(entries, Entry{id, e.digits, e.expiresAt})
+		}
+	}
+	return entries
+}
+
+// Collect removes the expired captchas now, rather than after the next
+// collectNum captchas are stored.
+func (s *cacheStore) Collect() {
+	s.collect()
+}
+
+// collect removes the captchas that expired, soonest first through the
+// expiration index.
 func (s *cacheStore) collect() {
 	now := time.Now()
 	s.Lock()
 	defer s.Unlock()
 	s.numStored = 0
-	for e := s.idByTime.Front(); e != nil; {
-		ev, ok := e.Value.(idByTimeValue)
-		if !ok {
-			return
-		}
-		if ev.timestamp.Add(s.expiration).Before(now) {
-			delete(s.digitsById, ev.id)
-			next := e.Next()
-			s.idByTime.Remove(e)
-			e = next
-		} else {
-			return
-		}
+	for len(s.idByTime) > 0 && s.idByTime[0].expiresAt.Before(now) {
+		e := heap.Pop(&s.idByTime).(*cacheEntry)
+		delete(s.digitsById, e.id)
 	}
 }
@@ -5,6 +5,7 @@
 package store
 
 import (
+	"context"
 	"bytes"
 	"github.com/roachapp/captcha/pkg/util"
 	"testing"
@@ -13,10 +14,11 @@ import (
 
 func TestSetGet(t *testing.T) {
 	s := NewCacheStore(100, 30 * time.Second)
+	ctx := context.Background()
 	id := "captcha id"
 	d := util.RandomDigits(10)
-	s.Set(id, d)
-	d2 := s.Get(id, false)
+	s.Set(ctx, id, d, time.Time{})
+	d2, _ := s.Get(ctx, id, false)
 	if d2 == nil || !bytes.Equal(d, d2) {
 		t.Errorf("saved %v, getDigits returned got %v", d, d2)
 	}
@@ -24,35 +26,74 @@ func TestSetGet(t *testing.T) {
 
 func TestGetClear(t *testing.T) {
 	s := NewCacheStore(100, 30 * time.Second)
+	ctx := context.Background()
 	id := "captcha id"
 	d := util.RandomDigits(10)
-	s.Set(id, d)
-	d2 := s.Get(id, true)
+	s.Set(ctx, id, d, time.Time{})
+	d2, _ := s.Get(ctx, id, true)
 	if d2 == nil || !bytes.Equal(d, d2) {
 		t.Errorf("saved %v, getDigitsClear returned got %v", d, d2)
 	}
-	d2 = s.Get(id, false)
+	d2, _ = s.Get(ctx, id, false)
 	if d2 != nil {
 		t.Errorf("getDigitClear didn't clear (%q=%v)", id, d2)
 	}
+	if n := len(s.(*cacheStore).idByTime); n != 0 {
+		t.Errorf("%d captchas left in the expiration index", n)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	s := NewCacheStore(100, 30 * time.Second)
+	ctx := context.Background()
+	d := util.RandomDigits(10)
+
+	before := time.Now()
+	s.Set(ctx, "default", d, time.Time{})
+	if _, exp := s.Get(ctx, "default", false); exp.Before(before.Add(30*time.Second)) {
+		t.Errorf("default expiration: got %v, expected 30s after %v", exp, before)
+	}
+	later := time.Now().Add(time.Hour)
+	s.Set(ctx, "later", d, later)
+	if d2, exp := s.Get(ctx, "later", false); !bytes.Equal(d, d2) || !exp.Equal(later) {
+		t.Errorf("got %v %v, expected %v %v", d2, exp, d, later)
+	}
+
+	past := time.Now().Add(-time.Second)
+	s.Set(ctx, "expired", d, past)
+	if d2, exp := s.Get(ctx, "expired", false); d2 != nil || !exp.Equal(past) {
+		t.Errorf("expired entry: got %v %v, expected nil %v", d2, exp, past)
+	}
+	if d2, exp := s.Get(ctx, "missing", false); d2 != nil || !exp.IsZero() {
+		t.Errorf("missing entry: got %v %v", d2, exp)
+	}
+
+	// Setting an id again replaces its expiration, and collections don't
+	// remove it at the former one.
+	s.Set(ctx, "expired", d, later)
+	s.(*cacheStore).collect()
+	if d2, _ := s.Get(ctx, "expired", false); d2 == nil {
+		t.Errorf("entry collected at its former expiration")
+	}
 }
 
 func TestCollect(t *testing.T) {
 	//TODO(dchest): can't test automatic collection when saving, because
 	//it's currently launched in a different goroutine.
 	s := NewCacheStore(10, -1)
+	ctx := context.Background()
 	// create 10 ids
 	ids := make([]string, 10)
 	d := util.RandomDigits(10)
 	for i := range ids {
 		ids[i] = util.RandomId()
-		s.Set(ids[i], d)
+		s.Set(ctx, ids[i], d, time.Time{})
 	}
 	s.(*cacheStore).collect()
 	// Must be already collected
 	nc := 0
 	for i := range ids {
-		d2 := s.Get(ids[i], false)
+		d2, _ := s.Get(ctx, ids[i], false)
 		if d2 != nil {
 			t.Errorf("%d: not collected", i)
 			nc++
@@ -63,10 +104,33 @@ func TestCollect(t *testing.T) {
 	}
 }
 
+func TestCollectBehindUnexpired(t *testing.T) {
+	s := NewCacheStore(100, time.Minute)
+	ctx := context.Background()
+	d := util.RandomDigits(10)
+	// A long-lived captcha at the front of the index mustn't keep the
+	// expired ones behind it.
+	s.Set(ctx, "long", d, time.Now().Add(time.Hour))
+	for i := 0; i < 10; i++ {
+		s.Set(ctx, util.RandomId(), d, time.Now().Add(-time.Second))
+	}
+	// Reloading moves a captcha in the index.
+	s.Set(ctx, "reloaded", d, time.Now().Add(time.Hour))
+	s.Set(ctx, "reloaded", d, time.Now().Add(-time.Second))
+	s.(*cacheStore).collect()
+	if n, _ := Len(s); n != 1 {
+		t.Errorf("%d captchas left, expected 1", n)
+	}
+	if d2, _ := s.Get(ctx, "long", false); d2 == nil {
+		t.Error("unexpired captcha collected")
+	}
+}
+
 func BenchmarkSetCollect(b *testing.B) {
 	b.StopTimer()
 	d := util.RandomDigits(10)
 	s := NewCacheStore(9999, -1)
+	ctx := context.Background()
 	ids := make([]string, 1000)
 	for i := range ids {
 		ids[i] = util.RandomId()
@@ -74,8 +138,27 @@ func BenchmarkSetCollect(b *testing.B) {
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < 1000; j++ {
-			s.Set(ids[j], d)
+			s.Set(ctx, ids[j], d, time.Time{})
 		}
 		s.(*cacheStore).collect()
 	}
 }
+
+func TestClose(t *testing.T) {
+	s := NewCacheStore(1, 30 * time.Second)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		s.Set(ctx, util.RandomId(), util.RandomDigits(10), time.Time{})
+	}
+	if err := Close(s); err != nil {
+		t.Fatal(err)
+	}
+	// Sets after Close must not start collections.
+	id := "captcha id"
+	s.Set(ctx, id, util.RandomDigits(10), time.Time{})
+	s.Set(ctx, util.RandomId(), util.RandomDigits(10), time.Time{})
+	s.(*cacheStore).collecting.Wait()
+	if d, _ := s.Get(ctx, id, false); d == nil {
+		t.Errorf("collected after Close")
+	}
+}
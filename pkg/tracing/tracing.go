@@ -0,0 +1,86 @@
+// Package tracing sets up OpenTelemetry tracing of the captcha server.
+//
+// Until Setup installs an exporter, the global tracer provider is the no-op
+// one of OpenTelemetry, so instrumented code costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// instrumentation is the name of the tracer of the captcha server.
+const instrumentation = "github.com/roachapp/captcha"
+
+// Config configures the exporter of spans.
+type Config struct {
+	// Exporter is ExporterNone, ExporterStdout or ExporterOTLP.
+	Exporter string
+	// Endpoint is the address of the OTLP gRPC collector. Defaults to
+	// localhost:4317.
+	Endpoint string
+	// Insecure disables TLS to the collector.
+	Insecure bool
+	// SampleRatio is the fraction of traces sampled, unless the parent span
+	// is sampled.
+	SampleRatio float64
+}
+
+// Tracer returns the tracer used by the packages of the captcha server.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator as configured. It returns a function that flushes and stops the
+// exporter, to call on shutdown.
+func Setup(ctx context.Context, c Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exp sdktrace.SpanExporter
+	switch c.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New()
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{}
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("captcha"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -5,12 +5,12 @@
 package util
 
 import (
-	"bytes"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
 	"math"
+	"sync"
 )
 
 const (
@@ -32,14 +32,42 @@ type Image struct {
 }
 
 // NewImage returns a new captcha image of the given width and height with the
-// given digits, where each digit must be in range 0-9.
+// given digits, where each digit must be in range 0-9. The image is rendered
+// with the current RNG key.
 func NewImage(id string, digits []byte, width, height int) *Image {
+	return newImage(currentRNGKey(), id, digits, width, height, nil)
+}
+
+// NewImageKey is like NewImage, but renders the image with the RNG key that
+// has the given id. It returns ErrUnknownRNGKey if there is no such key, since
+// another key would render another image than the one shown before.
+func NewImageKey(keyID string, id string, digits []byte, width, height int) (*Image, error) {
+	return NewImageColor(keyID, id, digits, width, height, nil)
+}
+
+// NewImageColor is like NewImageKey, but draws the digits in the given color,
+// and the background circles in shades of it. A nil color picks a random dark
+// one, like NewImageKey.
+func NewImageColor(keyID string, id string, digits []byte, width, height int, c color.Color) (*Image, error) {
+	key, ok := rngKey(keyID)
+	if !ok {
+		return nil, ErrUnknownRNGKey
+	}
+	return newImage(key, id, digits, width, height, c), nil
+}
+
+func newImage(key [32]byte, id string, digits []byte, width, height int, c color.Color) *Image {
 	m := new(Image)
 
 	// Initialize PRNG.
-	m.rng.Seed(deriveSeed(imageSeedPurpose, id, digits))
+	m.rng.Seed(deriveSeed(&key, imageSeedPurpose, id, digits))
 
-	m.Paletted = image.NewPaletted(image.Rect(0, 0, width, height), m.getRandomPalette())
+	m.Paletted = &image.Paletted{
+		Pix:     getPix(width*height, true),
+		Stride:  width,
+		Rect:    image.Rect(0, 0, width, height),
+		Palette: m.getRandomPalette(c),
+	}
 	m.calculateSizes(width, height, len(digits))
 	// Randomly position captcha inside the image.
 	maxx := width - (m.numWidth+m.dotSize)*len(digits) - m.dotSize
@@ -66,16 +94,22 @@ func NewImage(id string, digits []byte, width, height int) *Image {
 	return m
 }
 
-func (m *Image) getRandomPalette() color.Palette {
+func (m *Image) getRandomPalette(c color.Color) color.Palette {
 	p := make([]color.Color, circleCount+1)
 	// Transparent color.
 	p[0] = color.RGBA{0xFF, 0xFF, 0xFF, 0x00}
 	// Primary color.
-	prim := color.RGBA{
-		uint8(m.rng.Intn(129)),
-		uint8(m.rng.Intn(129)),
-		uint8(m.rng.Intn(129)),
-		0xFF,
+	var prim color.RGBA
+	if c != nil {
+		prim = color.RGBAModel.Convert(c).(color.RGBA)
+		prim.A = 0xFF
+	} else {
+		prim = color.RGBA{
+			uint8(m.rng.Intn(129)),
+			uint8(m.rng.Intn(129)),
+			uint8(m.rng.Intn(129)),
+			0xFF,
+		}
 	}
 	p[1] = prim
 	// Circle colors.
@@ -85,20 +119,75 @@ func (m *Image) getRandomPalette() color.Palette {
 	return p
 }
 
-// encodedPNG encodes an image to PNG and returns
-// the result as a byte slice.
-func (m *Image) encodedPNG() []byte {
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, m.Paletted); err != nil {
-		panic(err.Error())
+// Rendering is done on pixel buffers taken from pixPool, and PNG encoding
+// reuses the encoder state from encoderPool, so that rendering captchas in a
+// loop allocates next to nothing once the pools are warm.
+var (
+	pixPool     sync.Pool
+	encoderPool encoderBufferPool
+	encoder     = png.Encoder{BufferPool: &encoderPool}
+)
+
+// encoderBufferPool implements png.EncoderBufferPool with a sync.Pool.
+type encoderBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *encoderBufferPool) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+func (p *encoderBufferPool) Put(b *png.EncoderBuffer) {
+	p.pool.Put(b)
+}
+
+// getPix returns a pixel buffer of the given length from pixPool, cleared if
+// requested.
+func getPix(n int, clear bool) []byte {
+	if b, ok := pixPool.Get().(*[]byte); ok && cap(*b) >= n {
+		pix := (*b)[:n]
+		if clear {
+			for i := range pix {
+				pix[i] = 0
+			}
+		}
+		return pix
+	}
+	return make([]byte, n)
+}
+
+// putPix returns a pixel buffer to pixPool.
+func putPix(pix []byte) {
+	pixPool.Put(&pix)
+}
+
+// Release returns the pixel buffer of the image to the pool it was taken
+// from. The image must not be used after calling Release.
+func (m *Image) Release() {
+	if m.Paletted != nil {
+		putPix(m.Pix)
+		m.Paletted = nil
 	}
-	return buf.Bytes()
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
 }
 
 // WriteTo writes captcha image in PNG format into the given writer.
 func (m *Image) WriteTo(w io.Writer) (int64, error) {
-	n, err := w.Write(m.encodedPNG())
-	return int64(n), err
+	cw := countingWriter{w: w}
+	err := encoder.Encode(&cw, m.Paletted)
+	return cw.n, err
 }
 
 func (m *Image) calculateSizes(width, height, ncount int) {
@@ -138,9 +227,33 @@ func (m *Image) calculateSizes(width, height, ncount int) {
 	m.numHeight = int(nh)
 }
 
+// setPix sets the color index of a single pixel, ignoring pixels outside of
+// the image.
+func (m *Image) setPix(x, y int, colorIdx uint8) {
+	if x < 0 || y < 0 || x >= m.Stride || y >= m.Rect.Max.Y {
+		return
+	}
+	m.Pix[y*m.Stride+x] = colorIdx
+}
+
+// drawHorizLine fills the pixels between fromX and toX inclusive on the line
+// y, clipped to the image.
 func (m *Image) drawHorizLine(fromX, toX, y int, colorIdx uint8) {
-	for x := fromX; x <= toX; x++ {
-		m.SetColorIndex(x, y, colorIdx)
+	if y < 0 || y >= m.Rect.Max.Y {
+		return
+	}
+	if fromX < 0 {
+		fromX = 0
+	}
+	if toX >= m.Stride {
+		toX = m.Stride - 1
+	}
+	if fromX > toX {
+		return
+	}
+	row := m.Pix[y*m.Stride+fromX : y*m.Stride+toX+1]
+	for i := range row {
+		row[i] = colorIdx
 	}
 }
 
@@ -151,8 +264,8 @@ func (m *Image) drawCircle(x, y, radius int, colorIdx uint8) {
 	xo := 0
 	yo := radius
 
-	m.SetColorIndex(x, y+radius, colorIdx)
-	m.SetColorIndex(x, y-radius, colorIdx)
+	m.setPix(x, y+radius, colorIdx)
+	m.setPix(x, y-radius, colorIdx)
 	m.drawHorizLine(x-radius, x+radius, y, colorIdx)
 
 	for xo < yo {
@@ -215,22 +328,43 @@ func (m *Image) drawDigit(digit []byte, x, y int) {
 	}
 }
 
+// offsetPool holds scratch buffers for the row offsets computed by distort.
+var offsetPool sync.Pool
+
 func (m *Image) distort(amplude float64, period float64) {
 	w := m.Bounds().Max.X
 	h := m.Bounds().Max.Y
 
-	oldm := m.Paletted
-	newm := image.NewPaletted(image.Rect(0, 0, w, h), oldm.Palette)
+	// Vertical offsets only depend on the column, so compute them once.
+	yos, _ := offsetPool.Get().(*[]int)
+	if yos == nil || cap(*yos) < w {
+		s := make([]int, w)
+		yos = &s
+	}
+	yo := (*yos)[:w]
 
 	dx := 2.0 * math.Pi / period
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
-			xo := amplude * math.Sin(float64(y)*dx)
-			yo := amplude * math.Cos(float64(x)*dx)
-			newm.SetColorIndex(x, y, oldm.ColorIndexAt(x+int(xo), y+int(yo)))
+	for x := range yo {
+		yo[x] = int(amplude * math.Cos(float64(x)*dx))
+	}
+
+	src := m.Pix
+	dst := getPix(w*h, false)
+	for y := 0; y < h; y++ {
+		xo := int(amplude * math.Sin(float64(y)*dx))
+		row := dst[y*w : (y+1)*w]
+		for x := range row {
+			sx, sy := x+xo, y+yo[x]
+			if sx < 0 || sy < 0 || sx >= w || sy >= h {
+				row[x] = 0
+				continue
+			}
+			row[x] = src[sy*w+sx]
 		}
 	}
-	m.Paletted = newm
+	offsetPool.Put(yos)
+	putPix(src)
+	m.Pix = dst
 }
 
 func (m *Image) randomBrightness(c color.RGBA, max uint8) color.RGBA {
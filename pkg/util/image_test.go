@@ -5,6 +5,7 @@
 package util
 
 import (
+	"image/color"
 	"testing"
 )
 
@@ -17,6 +18,20 @@ func (bc *byteCounter) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+func TestNewImageColor(t *testing.T) {
+	d := RandomDigits(3)
+	id := RandomId()
+	c := color.RGBA{0x12, 0x34, 0x56, 0xFF}
+	m, err := NewImageColor(CurrentRNGKeyID(), id, d, StdWidth, StdHeight, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release()
+	if m.Palette[1] != c {
+		t.Errorf("primary color %v, expected %v", m.Palette[1], c)
+	}
+}
+
 func BenchmarkNewImage(b *testing.B) {
 	b.StopTimer()
 	d := RandomDigits(3)
@@ -40,3 +55,44 @@ func BenchmarkImageWriteTo(b *testing.B) {
 		counter.n = 0
 	}
 }
+
+func BenchmarkNewImageRelease(b *testing.B) {
+	b.StopTimer()
+	d := RandomDigits(3)
+	id := RandomId()
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		NewImage(id, d, StdWidth, StdHeight).Release()
+	}
+}
+
+func BenchmarkImageWriteToRelease(b *testing.B) {
+	b.StopTimer()
+	d := RandomDigits(3)
+	id := RandomId()
+	b.ReportAllocs()
+	b.StartTimer()
+	counter := &byteCounter{}
+	for i := 0; i < b.N; i++ {
+		img := NewImage(id, d, StdWidth, StdHeight)
+		img.WriteTo(counter)
+		img.Release()
+		b.SetBytes(counter.n)
+		counter.n = 0
+	}
+}
+
+func BenchmarkImageWriteToParallel(b *testing.B) {
+	d := RandomDigits(3)
+	id := RandomId()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		counter := &byteCounter{}
+		for pb.Next() {
+			img := NewImage(id, d, StdWidth, StdHeight)
+			img.WriteTo(counter)
+			img.Release()
+		}
+	})
+}
@@ -18,16 +18,6 @@ const idLen = 20
 // idChars are characters allowed in captcha id.
 var idChars = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
 
-// rngKey is a secret key used to deterministically derive seeds for
-// PRNGs used in image and audio. Generated once during initialization.
-var rngKey [32]byte
-
-func init() {
-	if _, err := io.ReadFull(rand.Reader, rngKey[:]); err != nil {
-		panic("captcha: error reading random source: " + err.Error())
-	}
-}
-
 // Purposes for seed derivation. The goal is to make deterministic PRNG produce
 // different outputs for images and audio by using different derived seeds.
 const (
@@ -35,15 +25,15 @@ const (
 	audioSeedPurpose = 0x02
 )
 
-// deriveSeed returns a 16-byte PRNG seed from rngKey, purpose, id and digits.
-// Same purpose, id and digits will result in the same derived seed for this
-// instance of running application.
+// deriveSeed returns a 16-byte PRNG seed from the key, purpose, id and digits.
+// Same key, purpose, id and digits will result in the same derived seed, so
+// replicas sharing the key render the same captcha (see RNGKey).
 //
-//   out = HMAC(rngKey, purpose || id || 0x00 || digits)  (cut to 16 bytes)
+//   out = HMAC(key, purpose || id || 0x00 || digits)  (cut to 16 bytes)
 //
-func deriveSeed(purpose byte, id string, digits []byte) (out [16]byte) {
+func deriveSeed(key *[32]byte, purpose byte, id string, digits []byte) (out [16]byte) {
 	var buf [sha256.Size]byte
-	h := hmac.New(sha256.New, rngKey[:])
+	h := hmac.New(sha256.New, key[:])
 	h.Write([]byte{purpose})
 	io.WriteString(h, id)
 	h.Write([]byte{0})
@@ -60,6 +50,11 @@ func RandomDigits(length int) []byte {
 	return randomBytesMod(length, 10)
 }
 
+// RandomBytes returns a byte slice of the given length read from CSPRNG.
+func RandomBytes(length int) []byte {
+	return randomBytes(length)
+}
+
 // randomBytes returns a byte slice of the given length read from CSPRNG.
 func randomBytes(length int) (b []byte) {
 	b = make([]byte, length)
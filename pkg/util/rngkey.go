@@ -0,0 +1,138 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// RNGKey is a secret key used to deterministically derive seeds for PRNGs
+// used in images. Replicas configured with the same keys render the same
+// image for the same captcha, and a restart doesn't change it either.
+//
+// Keys are tagged with an id, so that they can be rotated: captchas record
+// the id of the key they were issued with, and keep being rendered with it as
+// long as it's among the previous keys.
+type RNGKey struct {
+	ID  string
+	Key [32]byte
+}
+
+var (
+	rngMu      sync.RWMutex
+	rngCurrent RNGKey
+	rngKeys    map[string][32]byte
+)
+
+func init() {
+	// Until keys are configured, use a random key, which is only good for
+	// this instance of running application.
+	var k RNGKey
+	if _, err := io.ReadFull(rand.Reader, k.Key[:]); err != nil {
+		panic("captcha: error reading random source: " + err.Error())
+	}
+	SetRNGKeys(k)
+}
+
+// SetRNGKeys sets the key used to render new captchas, and the previous keys
+// still accepted to render captchas issued before rotation.
+func SetRNGKeys(current RNGKey, previous ...RNGKey) {
+	keys := make(map[string][32]byte, len(previous)+1)
+	for _, k := range previous {
+		keys[k.ID] = k.Key
+	}
+	keys[current.ID] = current.Key
+
+	rngMu.Lock()
+	rngCurrent = current
+	rngKeys = keys
+	rngMu.Unlock()
+}
+
+// CurrentRNGKeyID returns the id of the key used to render new captchas.
+func CurrentRNGKeyID() string {
+	rngMu.RLock()
+	defer rngMu.RUnlock()
+	return rngCurrent.ID
+}
+
+// ErrUnknownRNGKey is returned when rendering a captcha with a key that isn't
+// among the current and previous keys, for example after it was dropped from
+// the rotation, or on a replica configured with other keys.
+var ErrUnknownRNGKey = errors.New("captcha: unknown rng key")
+
+// rngKey returns the key with the given id, and false if there is no such
+// key.
+func rngKey(id string) ([32]byte, bool) {
+	rngMu.RLock()
+	defer rngMu.RUnlock()
+	k, ok := rngKeys[id]
+	return k, ok
+}
+
+// currentRNGKey returns the key used to render new captchas.
+func currentRNGKey() [32]byte {
+	rngMu.RLock()
+	defer rngMu.RUnlock()
+	return rngCurrent.Key
+}
+
+// ParseRNGKeys parses a list of keys separated by newlines or commas. Each key
+// is written as "id:hex", where hex is the 32-byte key in hexadecimal. The
+// first key is the current one, the rest are previous keys. Empty lines and
+// lines starting with '#' are ignored.
+func ParseRNGKeys(s string) (current RNGKey, previous []RNGKey, err error) {
+	var keys []RNGKey
+	seen := make(map[string]bool)
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.HasPrefix(f, "#") {
+			continue
+		}
+		i := strings.LastIndex(f, ":")
+		if i < 0 {
+			return current, nil, fmt.Errorf("captcha: rng key %q: missing key id", f)
+		}
+		var k RNGKey
+		k.ID = f[:i]
+		b, err := hex.DecodeString(f[i+1:])
+		if err != nil || len(b) != len(k.Key) {
+			return current, nil, fmt.Errorf("captcha: rng key %q: key must be %d hex-encoded bytes", k.ID, len(k.Key))
+		}
+		if seen[k.ID] {
+			return current, nil, fmt.Errorf("captcha: rng key %q: duplicate key id", k.ID)
+		}
+		seen[k.ID] = true
+		copy(k.Key[:], b)
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return current, nil, errors.New("captcha: no rng keys")
+	}
+	return keys[0], keys[1:], nil
+}
+
+// LoadRNGKeys parses the keys in the given string (see ParseRNGKeys) and sets
+// them with SetRNGKeys.
+func LoadRNGKeys(s string) error {
+	current, previous, err := ParseRNGKeys(s)
+	if err != nil {
+		return err
+	}
+	SetRNGKeys(current, previous...)
+	return nil
+}
+
+// LoadRNGKeysFile is like LoadRNGKeys, but reads the keys from a file.
+func LoadRNGKeysFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return LoadRNGKeys(string(b))
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseRNGKeys(t *testing.T) {
+	k1 := strings.Repeat("01", 32)
+	k0 := strings.Repeat("ab", 32)
+	cur, prev, err := ParseRNGKeys("# keys\nkey-1:" + k1 + "\n\nkey-0:" + k0 + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.ID != "key-1" || cur.Key[0] != 0x01 {
+		t.Errorf("wrong current key: %q %x", cur.ID, cur.Key)
+	}
+	if len(prev) != 1 || prev[0].ID != "key-0" || prev[0].Key[0] != 0xab {
+		t.Errorf("wrong previous keys: %v", prev)
+	}
+	if _, prev, err = ParseRNGKeys("a:" + k1 + ",b:" + k0); err != nil || len(prev) != 1 {
+		t.Errorf("comma-separated keys not parsed: %v %v", prev, err)
+	}
+
+	for _, s := range []string{"", k1, "a:abcd", "a:" + k1 + ",a:" + k0} {
+		if _, _, err := ParseRNGKeys(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestRNGKeyRotation(t *testing.T) {
+	defer SetRNGKeys(RNGKey{ID: CurrentRNGKeyID(), Key: currentRNGKey()})
+
+	old := RNGKey{ID: "old"}
+	copy(old.Key[:], RandomBytes(32))
+	SetRNGKeys(old)
+	id := RandomId()
+	d := RandomDigits(6)
+	m1 := NewImage(id, d, StdWidth, StdHeight)
+	m2, err := NewImageKey("old", id, d, StdWidth, StdHeight)
+	if err != nil || !bytes.Equal(m1.Pix, m2.Pix) {
+		t.Errorf("same key rendered different images")
+	}
+
+	cur := RNGKey{ID: "new"}
+	copy(cur.Key[:], RandomBytes(32))
+	SetRNGKeys(cur, old)
+	if CurrentRNGKeyID() != "new" {
+		t.Errorf("current key not rotated")
+	}
+	if m, err := NewImageKey("old", id, d, StdWidth, StdHeight); err != nil || !bytes.Equal(m1.Pix, m.Pix) {
+		t.Errorf("previous key rendered a different image after rotation: %v", err)
+	}
+	if m := NewImage(id, d, StdWidth, StdHeight); bytes.Equal(m1.Pix, m.Pix) {
+		t.Errorf("new key rendered the same image")
+	}
+
+	// Once dropped from the rotation, a key can't render anymore.
+	SetRNGKeys(cur)
+	if m, err := NewImageKey("old", id, d, StdWidth, StdHeight); err != ErrUnknownRNGKey || m != nil {
+		t.Errorf("dropped key: got %v, %v", m, err)
+	}
+}